@@ -1,6 +1,10 @@
 package cgroups
 
 import (
+	"errors"
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"io/fs"
 	"mydocker/cgroups/subsystems"
 )
 
@@ -9,26 +13,41 @@ type CgroupManager struct {
 	cgroupPath string
 	// 资源配置
 	Resource *subsystems.ResourceConfig
+	// 主机的cgroup挂载方式(v1/hybrid/v2)，unified时各子系统使用cgroup v2驱动
+	Mode subsystems.CgroupMode
 }
 
 func NewCgroupManager(cgroupPath string) *CgroupManager {
 	return &CgroupManager{
 		cgroupPath: cgroupPath,
+		Mode:       subsystems.GetCgroupMode(),
 	}
 }
 
-// 统一资源限制设置
+// 统一资源限制设置，主机上没有、且没有指定限制的子系统跳过，其余子系统设置失败时返回第一个错误
 func (c *CgroupManager) Set() error {
+	log.Infof("cgroup mode %s", c.Mode)
 	for _, subSysIns := range subsystems.SubSystemIns {
-		return subSysIns.Set(c.cgroupPath, c.Resource)
+		if err := subSysIns.Set(c.cgroupPath, c.Resource); err != nil {
+			if errors.Is(err, subsystems.ErrSubsystemUnavailable) && !c.Resource.HasLimits(subSysIns.Name()) {
+				log.Warnf("skip cgroup %s: %v", subSysIns.Name(), err)
+				continue
+			}
+			return fmt.Errorf("set cgroup %s error %v", subSysIns.Name(), err)
+		}
 	}
 	return nil
 }
 
-// 统一pid设置
+// 统一pid设置，进程没有加入某个子系统的cgroup时，该子系统的限制对它不生效，返回错误
 func (c *CgroupManager) Apply(pid int) error {
 	for _, subSysIns := range subsystems.SubSystemIns {
-		return subSysIns.Apply(c.cgroupPath, pid)
+		if err := subSysIns.Apply(c.cgroupPath, pid); err != nil {
+			if errors.Is(err, subsystems.ErrSubsystemUnavailable) {
+				continue
+			}
+			return fmt.Errorf("apply cgroup %s error %v", subSysIns.Name(), err)
+		}
 	}
 	return nil
 }
@@ -36,7 +55,29 @@ func (c *CgroupManager) Apply(pid int) error {
 // 统一cgroup移除
 func (c *CgroupManager) Destory() error {
 	for _, subSysIns := range subsystems.SubSystemIns {
-		return subSysIns.Remove(c.cgroupPath)
+		// cgroup v2下所有子系统共用一个目录，前面的子系统可能已经删除
+		if err := subSysIns.Remove(c.cgroupPath); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			log.Warnf("remove cgroup %s fail %v", subSysIns.Name(), err)
+		}
 	}
 	return nil
 }
+
+// 冻结或解冻cgroup中的所有进程
+func (c *CgroupManager) Freeze(state subsystems.FreezerState) error {
+	return (&subsystems.FreezerSubSystem{}).Freeze(c.cgroupPath, state)
+}
+
+// 汇总各子系统的资源使用统计
+func (c *CgroupManager) GetStats() (*subsystems.Stats, error) {
+	stats := &subsystems.Stats{}
+	for _, subSysIns := range subsystems.SubSystemIns {
+		if err := subSysIns.GetStats(c.cgroupPath, stats); err != nil {
+			if errors.Is(err, fs.ErrNotExist) {
+				return nil, fmt.Errorf("cgroup %s not exist", c.cgroupPath)
+			}
+			log.Warnf("get cgroup %s stats fail %v", subSysIns.Name(), err)
+		}
+	}
+	return stats, nil
+}
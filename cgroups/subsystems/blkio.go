@@ -0,0 +1,156 @@
+package subsystems
+
+import (
+	"fmt"
+	"golang.org/x/sys/unix"
+	"io/ioutil"
+	"mydocker/utils"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+)
+
+// 块设备io限制，v1使用blkio子系统，v2使用io控制器
+type BlkioSubSystem struct {
+}
+
+func (s *BlkioSubSystem) Name() string {
+	return "blkio"
+}
+
+func (s *BlkioSubSystem) Set(cgroupPath string, res *ResourceConfig) error {
+	if subsysCgroupPath, err := GetCgroupPath(s.Name(), cgroupPath, true); err == nil {
+		if IsCgroup2UnifiedMode() {
+			return s.setCgroup2(subsysCgroupPath, res)
+		}
+
+		if res.BlkioWeight != "" {
+			if err := ioutil.WriteFile(path.Join(subsysCgroupPath, "blkio.weight"), []byte(res.BlkioWeight), 0644); err != nil {
+				return fmt.Errorf("set cgroup blkio weight fail %v", err)
+			}
+		}
+
+		// 每个设备需要单独写一次 "major:minor value"
+		throttles := []struct {
+			file   string
+			values []string
+			bytes  bool
+		}{
+			{"blkio.throttle.read_bps_device", res.DeviceReadBps, true},
+			{"blkio.throttle.write_bps_device", res.DeviceWriteBps, true},
+			{"blkio.throttle.read_iops_device", res.DeviceReadIOps, false},
+			{"blkio.throttle.write_iops_device", res.DeviceWriteIOps, false},
+		}
+		for _, throttle := range throttles {
+			for _, value := range throttle.values {
+				device, rate, err := parseThrottleDevice(value, throttle.bytes)
+				if err != nil {
+					return err
+				}
+				line := fmt.Sprintf("%s %d", device, rate)
+				if err := ioutil.WriteFile(path.Join(subsysCgroupPath, throttle.file), []byte(line), 0644); err != nil {
+					return fmt.Errorf("set cgroup %s fail %v", throttle.file, err)
+				}
+			}
+		}
+		return nil
+	} else {
+		return err
+	}
+}
+
+// v2中权重写io.weight，限速统一写io.max："major:minor rbps=x wbps=x riops=x wiops=x"
+func (s *BlkioSubSystem) setCgroup2(subsysCgroupPath string, res *ResourceConfig) error {
+	if res.BlkioWeight != "" {
+		weight, err := strconv.ParseUint(res.BlkioWeight, 10, 64)
+		if err != nil {
+			return fmt.Errorf("parse blkio weight %s fail %v", res.BlkioWeight, err)
+		}
+		value := "default " + strconv.FormatUint(convertBlkIOToIOWeightValue(weight), 10)
+		if err := ioutil.WriteFile(path.Join(subsysCgroupPath, "io.weight"), []byte(value), 0644); err != nil {
+			return fmt.Errorf("set cgroup io weight fail %v", err)
+		}
+	}
+
+	throttles := []struct {
+		key    string
+		values []string
+		bytes  bool
+	}{
+		{"rbps", res.DeviceReadBps, true},
+		{"wbps", res.DeviceWriteBps, true},
+		{"riops", res.DeviceReadIOps, false},
+		{"wiops", res.DeviceWriteIOps, false},
+	}
+	for _, throttle := range throttles {
+		for _, value := range throttle.values {
+			device, rate, err := parseThrottleDevice(value, throttle.bytes)
+			if err != nil {
+				return err
+			}
+			line := fmt.Sprintf("%s %s=%d", device, throttle.key, rate)
+			if err := ioutil.WriteFile(path.Join(subsysCgroupPath, "io.max"), []byte(line), 0644); err != nil {
+				return fmt.Errorf("set cgroup io.max fail %v", err)
+			}
+		}
+	}
+	return nil
+}
+
+func (s *BlkioSubSystem) Apply(cgroupPath string, pid int) error {
+	if subsysCgroupPath, err := GetCgroupPath(s.Name(), cgroupPath, false); err == nil {
+		if err := ioutil.WriteFile(path.Join(subsysCgroupPath, procsFileName()), []byte(strconv.Itoa(pid)), 0644); err != nil {
+			return fmt.Errorf("set cgroup proc fail %v", err)
+		}
+		return nil
+	} else {
+		return err
+	}
+}
+
+func (s *BlkioSubSystem) Remove(cgroupPath string) error {
+	if subsysCgroupPath, err := GetCgroupPath(s.Name(), cgroupPath, false); err == nil {
+		return os.RemoveAll(subsysCgroupPath)
+	} else {
+		return err
+	}
+}
+
+func (s *BlkioSubSystem) GetStats(cgroupPath string, stats *Stats) error {
+	return nil
+}
+
+// 解析 "设备路径:速率" 格式的限速参数，如 /dev/sda:1mb、/dev/sda:1000，返回 "major:minor" 和速率
+func parseThrottleDevice(value string, bytes bool) (string, uint64, error) {
+	idx := strings.LastIndex(value, ":")
+	if idx <= 0 || idx == len(value)-1 {
+		return "", 0, fmt.Errorf("bad format %s, expect <device-path>:<rate>", value)
+	}
+	devicePath, rateStr := value[:idx], value[idx+1:]
+
+	var stat unix.Stat_t
+	if err := unix.Stat(devicePath, &stat); err != nil {
+		return "", 0, fmt.Errorf("stat device %s fail %v", devicePath, err)
+	}
+	if stat.Mode&unix.S_IFMT != unix.S_IFBLK {
+		return "", 0, fmt.Errorf("%s is not a block device", devicePath)
+	}
+	device := fmt.Sprintf("%d:%d", unix.Major(stat.Rdev), unix.Minor(stat.Rdev))
+
+	var rate uint64
+	if bytes {
+		n, err := utils.RAMInBytes(rateStr)
+		if err != nil {
+			return "", 0, err
+		}
+		rate = uint64(n)
+	} else {
+		n, err := strconv.ParseUint(rateStr, 10, 64)
+		if err != nil {
+			return "", 0, fmt.Errorf("invalid iops %s", rateStr)
+		}
+		rate = n
+	}
+	return device, rate, nil
+}
@@ -0,0 +1,95 @@
+package subsystems
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"strings"
+)
+
+// v1 cpu.shares的取值范围
+const (
+	minCPUShares = 2
+	maxCPUShares = 262144
+)
+
+// cgroup v2 下所有控制器共用同一棵树，v1子系统名称与v2控制器名称的对应关系
+var cgroup2Controllers = map[string]string{
+	"cpu":     "cpu",
+	"cpuacct": "cpu",
+	"cpuset":  "cpuset",
+	"memory":  "memory",
+	"blkio":   "io",
+	"pids":    "pids",
+}
+
+// 获取（新建）cgroup v2下的cgroup目录，并在各级父cgroup中开启子系统对应的控制器
+func getCgroup2Path(subsystem, cgroupPath string, autoCreate bool) (string, error) {
+	cgroupDir := path.Join(cgroupMountpoint, cgroupPath)
+	if _, err := os.Stat(cgroupDir); err != nil {
+		if !autoCreate || !os.IsNotExist(err) {
+			return "", fmt.Errorf("cgroup path error %w", err)
+		}
+		if err := os.MkdirAll(cgroupDir, 0755); err != nil {
+			return "", fmt.Errorf("error create cgroup %v", err)
+		}
+	}
+
+	if autoCreate {
+		if controller, ok := cgroup2Controllers[subsystem]; ok {
+			if err := enableControllers(cgroupPath, controller); err != nil {
+				return "", err
+			}
+		}
+	}
+	return cgroupDir, nil
+}
+
+// 从根cgroup开始，逐级向cgroup.subtree_control写入"+controller"，子cgroup才会出现对应的接口文件
+func enableControllers(cgroupPath, controller string) error {
+	current := cgroupMountpoint
+	for _, elem := range strings.Split(strings.Trim(cgroupPath, "/"), "/") {
+		content, err := os.ReadFile(path.Join(current, "cgroup.controllers"))
+		if err != nil {
+			return fmt.Errorf("read %s cgroup.controllers error %v", current, err)
+		}
+		available := false
+		for _, c := range strings.Fields(string(content)) {
+			if c == controller {
+				available = true
+				break
+			}
+		}
+		if !available {
+			return fmt.Errorf("%w: controller %s is not available in %s", ErrSubsystemUnavailable, controller, current)
+		}
+
+		if err := os.WriteFile(path.Join(current, "cgroup.subtree_control"), []byte("+"+controller), 0644); err != nil {
+			return fmt.Errorf("enable controller %s in %s error %v", controller, current, err)
+		}
+		current = path.Join(current, elem)
+	}
+	return nil
+}
+
+// cpu.shares(2-262144)换算为cpu.weight(1-10000)，与runc的换算方式保持一致
+// 超出范围的值与v1内核的处理一样截断到2-262144
+func convertCPUSharesToCgroupV2Value(shares uint64) uint64 {
+	if shares == 0 {
+		return 0
+	}
+	if shares < minCPUShares {
+		shares = minCPUShares
+	} else if shares > maxCPUShares {
+		shares = maxCPUShares
+	}
+	return 1 + ((shares-minCPUShares)*9999)/(maxCPUShares-minCPUShares)
+}
+
+// blkio.weight(10-1000)换算为io.weight(1-10000)
+func convertBlkIOToIOWeightValue(weight uint64) uint64 {
+	if weight == 0 {
+		return 0
+	}
+	return 1 + (weight-10)*9999/990
+}
@@ -6,6 +6,7 @@ import (
 	"os"
 	"path"
 	"strconv"
+	"strings"
 )
 
 // 定义结构体，并实现subsystem接口
@@ -17,27 +18,78 @@ func (s *CpuSubSystem) Name() string {
 	return "cpu"
 }
 
-// 将cpu资源限制写入cpu cgroup目录下的cpu.shares文件中
+// 将cpu资源限制写入cpu cgroup目录下的cpu.shares文件中，配额写入cpu.cfs_quota_us/cpu.cfs_period_us(v2为cpu.max)
 func (s *CpuSubSystem) Set(cgroupPath string, res *ResourceConfig) error {
 	// 获取（新建）目录/sys/fs/cgroup/cpu,cpuacct
 	if subsysCgroupPath, err := GetCgroupPath(s.Name(), cgroupPath, true); err == nil {
 		if res.CpuShare != "" {
-			// 将res.CpuShare配置写入到/sys/fs/cgroup/cpu,cpuacct/cpu.shares文件中
-			if err := ioutil.WriteFile(path.Join(subsysCgroupPath, "cpu.shares"), []byte(res.CpuShare), 0644); err != nil {
-				return fmt.Errorf("set cgroup share fail %v", err)
+			if IsCgroup2UnifiedMode() {
+				// cgroup v2没有cpu.shares，换算为cpu.weight
+				shares, err := strconv.ParseUint(res.CpuShare, 10, 64)
+				if err != nil {
+					return fmt.Errorf("parse cpushare %s fail %v", res.CpuShare, err)
+				}
+				weight := strconv.FormatUint(convertCPUSharesToCgroupV2Value(shares), 10)
+				if err := ioutil.WriteFile(path.Join(subsysCgroupPath, "cpu.weight"), []byte(weight), 0644); err != nil {
+					return fmt.Errorf("set cgroup weight fail %v", err)
+				}
+			} else {
+				// 将res.CpuShare配置写入到/sys/fs/cgroup/cpu,cpuacct/cpu.shares文件中
+				if err := ioutil.WriteFile(path.Join(subsysCgroupPath, "cpu.shares"), []byte(res.CpuShare), 0644); err != nil {
+					return fmt.Errorf("set cgroup share fail %v", err)
+				}
 			}
 		}
-		return nil
+		return s.setQuota(subsysCgroupPath, res)
 	} else {
 		return err
 	}
 
 }
 
-// 将对应进程的pid写入cpu cgroup下的tasks文件中
+// 设置cpu硬限制：每个period(微秒)内最多使用quota(微秒)的cpu时间
+func (s *CpuSubSystem) setQuota(subsysCgroupPath string, res *ResourceConfig) error {
+	quota, period, err := cpuQuotaAndPeriod(res)
+	if err != nil {
+		return err
+	}
+	if quota == "" && period == "" {
+		return nil
+	}
+
+	if IsCgroup2UnifiedMode() {
+		// cpu.max格式为"$MAX $PERIOD"，"max"表示不限制
+		if quota == "" || strings.HasPrefix(quota, "-") {
+			quota = "max"
+		}
+		value := quota
+		if period != "" {
+			value += " " + period
+		}
+		if err := ioutil.WriteFile(path.Join(subsysCgroupPath, "cpu.max"), []byte(value), 0644); err != nil {
+			return fmt.Errorf("set cgroup cpu.max fail %v", err)
+		}
+		return nil
+	}
+
+	// 先写period再写quota，否则quota可能因超出原period的范围而写入失败
+	if period != "" {
+		if err := ioutil.WriteFile(path.Join(subsysCgroupPath, "cpu.cfs_period_us"), []byte(period), 0644); err != nil {
+			return fmt.Errorf("set cgroup cpu period fail %v", err)
+		}
+	}
+	if quota != "" {
+		if err := ioutil.WriteFile(path.Join(subsysCgroupPath, "cpu.cfs_quota_us"), []byte(quota), 0644); err != nil {
+			return fmt.Errorf("set cgroup cpu quota fail %v", err)
+		}
+	}
+	return nil
+}
+
+// 将对应进程的pid写入cpu cgroup下的tasks文件中（v2为cgroup.procs）
 func (s *CpuSubSystem) Apply(cgroupPath string, pid int) error {
 	if subsysCgroupPath, err := GetCgroupPath(s.Name(), cgroupPath, false); err == nil {
-		if err := ioutil.WriteFile(path.Join(subsysCgroupPath, "tasks"), []byte(strconv.Itoa(pid)), 0644); err != nil {
+		if err := ioutil.WriteFile(path.Join(subsysCgroupPath, procsFileName()), []byte(strconv.Itoa(pid)), 0644); err != nil {
 			return fmt.Errorf("set cgroup proc fail %v", err)
 		}
 		return nil
@@ -55,3 +107,47 @@ func (s *CpuSubSystem) Remove(cgroupPath string) error {
 		return err
 	}
 }
+
+// 读取cpu.stat中的限流统计，cpu使用时间统计由cpuacct子系统提供
+func (s *CpuSubSystem) GetStats(cgroupPath string, stats *Stats) error {
+	subsysCgroupPath, err := GetCgroupPath(s.Name(), cgroupPath, false)
+	if err != nil {
+		return err
+	}
+	values, err := readKeyValues(subsysCgroupPath, "cpu.stat")
+	if err != nil {
+		return err
+	}
+	stats.CpuNrPeriods = values["nr_periods"]
+	stats.CpuNrThrottled = values["nr_throttled"]
+	// v1的throttled_time单位为纳秒，v2的throttled_usec单位为微秒
+	if IsCgroup2UnifiedMode() {
+		stats.CpuThrottledTime = values["throttled_usec"] * 1000
+	} else {
+		stats.CpuThrottledTime = values["throttled_time"]
+	}
+	return nil
+}
+
+// 默认的cfs调度周期(微秒)
+const defaultCpuPeriod = 100000
+
+// 根据--cpus或--cpu-quota/--cpu-period计算实际写入的quota和period，--cpus 1.5 即每100ms使用150ms的cpu时间
+func cpuQuotaAndPeriod(res *ResourceConfig) (string, string, error) {
+	if res.Cpus == "" {
+		return res.CpuQuota, res.CpuPeriod, nil
+	}
+
+	cpus, err := strconv.ParseFloat(res.Cpus, 64)
+	if err != nil || cpus <= 0 {
+		return "", "", fmt.Errorf("invalid cpus %s", res.Cpus)
+	}
+	period := uint64(defaultCpuPeriod)
+	if res.CpuPeriod != "" {
+		if period, err = strconv.ParseUint(res.CpuPeriod, 10, 64); err != nil {
+			return "", "", fmt.Errorf("invalid cpu period %s", res.CpuPeriod)
+		}
+	}
+	quota := uint64(cpus * float64(period))
+	return strconv.FormatUint(quota, 10), strconv.FormatUint(period, 10), nil
+}
@@ -0,0 +1,25 @@
+package subsystems
+
+import "testing"
+
+func TestCpuQuotaAndPeriod(t *testing.T) {
+	cases := []struct {
+		res           ResourceConfig
+		quota, period string
+	}{
+		{ResourceConfig{Cpus: "1.5"}, "150000", "100000"},
+		{ResourceConfig{Cpus: "0.5", CpuPeriod: "50000"}, "25000", "50000"},
+		{ResourceConfig{CpuQuota: "20000", CpuPeriod: "10000"}, "20000", "10000"},
+		{ResourceConfig{}, "", ""},
+	}
+	for _, c := range cases {
+		quota, period, err := cpuQuotaAndPeriod(&c.res)
+		if err != nil || quota != c.quota || period != c.period {
+			t.Errorf("%+v: got %s %s %v, want %s %s", c.res, quota, period, err, c.quota, c.period)
+		}
+	}
+
+	if _, _, err := cpuQuotaAndPeriod(&ResourceConfig{Cpus: "-1"}); err == nil {
+		t.Errorf("negative cpus should fail")
+	}
+}
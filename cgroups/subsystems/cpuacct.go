@@ -0,0 +1,68 @@
+package subsystems
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+)
+
+// cpuacct子系统本身不做限制，只用于统计cgroup中进程的cpu使用情况
+type CpuacctSubSystem struct {
+}
+
+func (s *CpuacctSubSystem) Name() string {
+	return "cpuacct"
+}
+
+// 只创建cgroup目录，没有需要设置的资源
+func (s *CpuacctSubSystem) Set(cgroupPath string, res *ResourceConfig) error {
+	_, err := GetCgroupPath(s.Name(), cgroupPath, true)
+	return err
+}
+
+func (s *CpuacctSubSystem) Apply(cgroupPath string, pid int) error {
+	if subsysCgroupPath, err := GetCgroupPath(s.Name(), cgroupPath, false); err == nil {
+		if err := ioutil.WriteFile(path.Join(subsysCgroupPath, procsFileName()), []byte(strconv.Itoa(pid)), 0644); err != nil {
+			return fmt.Errorf("set cgroup proc fail %v", err)
+		}
+		return nil
+	} else {
+		return err
+	}
+}
+
+func (s *CpuacctSubSystem) Remove(cgroupPath string) error {
+	if subsysCgroupPath, err := GetCgroupPath(s.Name(), cgroupPath, false); err == nil {
+		return os.RemoveAll(subsysCgroupPath)
+	} else {
+		return err
+	}
+}
+
+// 读取累计cpu时间：v1为cpuacct.usage(纳秒)，v2为cpu.stat中的usage_usec(微秒)
+func (s *CpuacctSubSystem) GetStats(cgroupPath string, stats *Stats) error {
+	subsysCgroupPath, err := GetCgroupPath(s.Name(), cgroupPath, false)
+	if err != nil {
+		return err
+	}
+	if IsCgroup2UnifiedMode() {
+		values, err := readKeyValues(subsysCgroupPath, "cpu.stat")
+		if err != nil {
+			return err
+		}
+		stats.CpuUsage = values["usage_usec"] * 1000
+	} else if stats.CpuUsage, err = readUint64(subsysCgroupPath, "cpuacct.usage"); err != nil {
+		return err
+	}
+
+	// cgroup.procs中每行一个进程
+	content, err := ioutil.ReadFile(path.Join(subsysCgroupPath, "cgroup.procs"))
+	if err != nil {
+		return fmt.Errorf("read cgroup procs fail %v", err)
+	}
+	stats.PidsCurrent = uint64(len(strings.Fields(string(content))))
+	return nil
+}
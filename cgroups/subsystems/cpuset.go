@@ -6,6 +6,7 @@ import (
 	"os"
 	"path"
 	"strconv"
+	"strings"
 )
 
 type CpusetSubSystem struct {
@@ -17,6 +18,15 @@ func (s *CpusetSubSystem) Name() string {
 
 func (s *CpusetSubSystem) Set(cgroupPath string, res *ResourceConfig) error {
 	if subsysCgroupPath, err := GetCgroupPath(s.Name(), cgroupPath, true); err == nil {
+		if !IsCgroup2UnifiedMode() {
+			// v1中新建的cpuset cgroup的cpus和mems为空，此时无法加入进程，需要从父cgroup继承
+			if err := initCpusetFromParent(FindCgroupMountPoint(s.Name()), cgroupPath); err != nil {
+				return err
+			}
+		}
+		if res.CpuSet == "" {
+			return nil
+		}
 		if err := ioutil.WriteFile(path.Join(subsysCgroupPath, "cpuset.cpus"), []byte(res.CpuSet), 0644); err != nil {
 			return fmt.Errorf("set cgroup cpuset fail %v", err)
 		}
@@ -28,7 +38,7 @@ func (s *CpusetSubSystem) Set(cgroupPath string, res *ResourceConfig) error {
 
 func (s *CpusetSubSystem) Apply(cgroupPath string, pid int) error {
 	if subsysCgroupPath, err := GetCgroupPath(s.Name(), cgroupPath, false); err == nil {
-		if err := ioutil.WriteFile(path.Join(subsysCgroupPath, "tasks"), []byte(strconv.Itoa(pid)), 0644); err != nil {
+		if err := ioutil.WriteFile(path.Join(subsysCgroupPath, procsFileName()), []byte(strconv.Itoa(pid)), 0644); err != nil {
 			return fmt.Errorf("set cgroup proc fail %v", err)
 		}
 		return nil
@@ -45,3 +55,33 @@ func (s *CpusetSubSystem) Remove(cgroupPath string) error {
 		return err
 	}
 }
+
+func (s *CpusetSubSystem) GetStats(cgroupPath string, stats *Stats) error {
+	return nil
+}
+
+// 从cpuset根目录开始逐级检查，cpuset.cpus/cpuset.mems为空的cgroup从父cgroup复制一份
+func initCpusetFromParent(cgroupRoot, cgroupPath string) error {
+	parent := cgroupRoot
+	for _, elem := range strings.Split(strings.Trim(cgroupPath, "/"), "/") {
+		current := path.Join(parent, elem)
+		for _, file := range []string{"cpuset.cpus", "cpuset.mems"} {
+			content, err := ioutil.ReadFile(path.Join(current, file))
+			if err != nil {
+				return fmt.Errorf("read %s error %v", path.Join(current, file), err)
+			}
+			if strings.TrimSpace(string(content)) != "" {
+				continue
+			}
+			parentContent, err := ioutil.ReadFile(path.Join(parent, file))
+			if err != nil {
+				return fmt.Errorf("read %s error %v", path.Join(parent, file), err)
+			}
+			if err := ioutil.WriteFile(path.Join(current, file), parentContent, 0644); err != nil {
+				return fmt.Errorf("init %s error %v", path.Join(current, file), err)
+			}
+		}
+		parent = current
+	}
+	return nil
+}
@@ -0,0 +1,111 @@
+package subsystems
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// FreezerState cgroup中进程的冻结状态
+type FreezerState string
+
+const (
+	Frozen FreezerState = "FROZEN"
+	Thawed FreezerState = "THAWED"
+)
+
+// 冻结/解冻cgroup中的所有进程，用于pause/unpause。v1使用freezer子系统，v2的cgroup.freeze是内置接口，不需要开启控制器
+type FreezerSubSystem struct {
+}
+
+func (s *FreezerSubSystem) Name() string {
+	return "freezer"
+}
+
+// freezer没有资源限制，只需要建好cgroup目录，容器进程加入后才能被冻结
+func (s *FreezerSubSystem) Set(cgroupPath string, res *ResourceConfig) error {
+	_, err := GetCgroupPath(s.Name(), cgroupPath, true)
+	return err
+}
+
+func (s *FreezerSubSystem) Apply(cgroupPath string, pid int) error {
+	if subsysCgroupPath, err := GetCgroupPath(s.Name(), cgroupPath, false); err == nil {
+		if err := ioutil.WriteFile(path.Join(subsysCgroupPath, procsFileName()), []byte(strconv.Itoa(pid)), 0644); err != nil {
+			return fmt.Errorf("set cgroup proc fail %v", err)
+		}
+		return nil
+	} else {
+		return err
+	}
+}
+
+func (s *FreezerSubSystem) Remove(cgroupPath string) error {
+	if subsysCgroupPath, err := GetCgroupPath(s.Name(), cgroupPath, false); err == nil {
+		return os.RemoveAll(subsysCgroupPath)
+	} else {
+		return err
+	}
+}
+
+func (s *FreezerSubSystem) GetStats(cgroupPath string, stats *Stats) error {
+	return nil
+}
+
+// Freeze 设置cgroup的冻结状态，并等待内核完成冻结/解冻
+func (s *FreezerSubSystem) Freeze(cgroupPath string, state FreezerState) error {
+	subsysCgroupPath, err := GetCgroupPath(s.Name(), cgroupPath, false)
+	if err != nil {
+		return err
+	}
+	if IsCgroup2UnifiedMode() {
+		return freezeCgroup2(subsysCgroupPath, state)
+	}
+
+	// v1写freezer.state，冻结过程中读到的是FREEZING
+	for i := 0; i < freezeRetries; i++ {
+		// 有进程处于不可冻结的状态时会一直停在FREEZING，需要重新写入触发冻结
+		if i%50 == 0 {
+			if err := writeCgroupFile(subsysCgroupPath, "freezer.state", string(state)); err != nil {
+				return err
+			}
+		}
+		content, err := ioutil.ReadFile(path.Join(subsysCgroupPath, "freezer.state"))
+		if err != nil {
+			return fmt.Errorf("read freezer state error %v", err)
+		}
+		if strings.TrimSpace(string(content)) == string(state) {
+			return nil
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	return fmt.Errorf("timeout waiting for cgroup %s to be %s", cgroupPath, state)
+}
+
+// 冻结/解冻时轮询状态的次数，每次间隔10ms
+const freezeRetries = 1000
+
+// v2写cgroup.freeze，完成后cgroup.events中的frozen变为对应的值
+func freezeCgroup2(cgroupDir string, state FreezerState) error {
+	value := uint64(0)
+	if state == Frozen {
+		value = 1
+	}
+	if err := writeCgroupFile(cgroupDir, "cgroup.freeze", strconv.FormatUint(value, 10)); err != nil {
+		return err
+	}
+	for i := 0; i < freezeRetries; i++ {
+		events, err := readKeyValues(cgroupDir, "cgroup.events")
+		if err != nil {
+			return err
+		}
+		if events["frozen"] == value {
+			return nil
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	return fmt.Errorf("timeout waiting for cgroup %s to be %s", cgroupDir, state)
+}
@@ -2,7 +2,9 @@ package subsystems
 
 import (
 	"fmt"
+	log "github.com/sirupsen/logrus"
 	"io/ioutil"
+	"mydocker/utils"
 	"os"
 	"path"
 	"strconv"
@@ -12,13 +14,82 @@ type MemorySubSystem struct {
 }
 
 func (s *MemorySubSystem) Name() string {
-	return "cpuset"
+	return "memory"
 }
 
 func (s *MemorySubSystem) Set(cgroupPath string, res *ResourceConfig) error {
 	if subsysCgroupPath, err := GetCgroupPath(s.Name(), cgroupPath, true); err == nil {
-		if err := ioutil.WriteFile(path.Join(subsysCgroupPath, "memory.limit_in_bytes"), []byte(res.CpuSet), 0644); err != nil {
-			return fmt.Errorf("set cgroup memory fail %v", err)
+		if IsCgroup2UnifiedMode() {
+			return s.setCgroup2(subsysCgroupPath, res)
+		}
+
+		limit, err := parseMemory(res.MemoryLimit)
+		if err != nil {
+			return err
+		}
+		swap, err := parseMemory(res.MemorySwap)
+		if err != nil {
+			return err
+		}
+		// memory.memsw.limit_in_bytes(内存+swap)不能小于memory.limit_in_bytes，
+		// 调大内存限制时要先调大memsw，调小时要先调小limit
+		limitFirst := true
+		if limit != 0 && swap != 0 {
+			current, err := readUint64(subsysCgroupPath, "memory.limit_in_bytes")
+			if err != nil {
+				return err
+			}
+			limitFirst = limit != -1 && uint64(limit) <= current
+		}
+		writeLimit := func() error {
+			if limit == 0 {
+				return nil
+			}
+			return writeCgroupFile(subsysCgroupPath, "memory.limit_in_bytes", strconv.FormatInt(limit, 10))
+		}
+		writeSwap := func() error {
+			if swap == 0 {
+				return nil
+			}
+			return writeCgroupFile(subsysCgroupPath, "memory.memsw.limit_in_bytes", strconv.FormatInt(swap, 10))
+		}
+		if limitFirst {
+			err = writeLimit()
+			if err == nil {
+				err = writeSwap()
+			}
+		} else {
+			err = writeSwap()
+			if err == nil {
+				err = writeLimit()
+			}
+		}
+		if err != nil {
+			return err
+		}
+
+		if reservation, err := parseMemory(res.MemoryReservation); err != nil {
+			return err
+		} else if reservation != 0 {
+			if err := writeCgroupFile(subsysCgroupPath, "memory.soft_limit_in_bytes", strconv.FormatInt(reservation, 10)); err != nil {
+				return err
+			}
+		}
+		if kernelMemory, err := parseMemory(res.KernelMemory); err != nil {
+			return err
+		} else if kernelMemory != 0 {
+			if err := writeCgroupFile(subsysCgroupPath, "memory.kmem.limit_in_bytes", strconv.FormatInt(kernelMemory, 10)); err != nil {
+				return err
+			}
+		}
+		if res.OomKillDisable != nil {
+			oomControl := "0"
+			if *res.OomKillDisable {
+				oomControl = "1"
+			}
+			if err := writeCgroupFile(subsysCgroupPath, "memory.oom_control", oomControl); err != nil {
+				return err
+			}
 		}
 		return nil
 	} else {
@@ -26,9 +97,65 @@ func (s *MemorySubSystem) Set(cgroupPath string, res *ResourceConfig) error {
 	}
 }
 
+// v2中memory.max为内存限制，memory.swap.max只包含swap部分，memory.low为内存预留
+func (s *MemorySubSystem) setCgroup2(subsysCgroupPath string, res *ResourceConfig) error {
+	limit, err := parseMemory(res.MemoryLimit)
+	if err != nil {
+		return err
+	}
+	if limit != 0 {
+		if err := writeCgroupFile(subsysCgroupPath, "memory.max", cgroup2MemoryValue(limit)); err != nil {
+			return err
+		}
+	}
+
+	swap, err := parseMemory(res.MemorySwap)
+	if err != nil {
+		return err
+	}
+	if swap != 0 {
+		swapOnly := int64(-1)
+		if swap != -1 {
+			// --memory-swap表示内存+swap的总量，需要减去内存限制
+			if limit == 0 {
+				current, err := readUint64(subsysCgroupPath, "memory.max")
+				if err != nil {
+					return err
+				}
+				limit = int64(current)
+			}
+			if limit <= 0 {
+				return fmt.Errorf("memory-swap requires a memory limit")
+			}
+			if swap < limit {
+				return fmt.Errorf("memory-swap %d should not be less than memory limit %d", swap, limit)
+			}
+			swapOnly = swap - limit
+		}
+		if err := writeCgroupFile(subsysCgroupPath, "memory.swap.max", cgroup2MemoryValue(swapOnly)); err != nil {
+			return err
+		}
+	}
+
+	if reservation, err := parseMemory(res.MemoryReservation); err != nil {
+		return err
+	} else if reservation != 0 {
+		if err := writeCgroupFile(subsysCgroupPath, "memory.low", cgroup2MemoryValue(reservation)); err != nil {
+			return err
+		}
+	}
+	if res.KernelMemory != "" {
+		log.Warnf("kernel memory limit is not supported in cgroup v2, ignored")
+	}
+	if res.OomKillDisable != nil && *res.OomKillDisable {
+		log.Warnf("oom kill disable is not supported in cgroup v2, ignored")
+	}
+	return nil
+}
+
 func (s *MemorySubSystem) Apply(cgroupPath string, pid int) error {
 	if subsysCgroupPath, err := GetCgroupPath(s.Name(), cgroupPath, false); err == nil {
-		if err := ioutil.WriteFile(path.Join(subsysCgroupPath, "tasks"), []byte(strconv.Itoa(pid)), 0644); err != nil {
+		if err := ioutil.WriteFile(path.Join(subsysCgroupPath, procsFileName()), []byte(strconv.Itoa(pid)), 0644); err != nil {
 			return fmt.Errorf("set cgroup proc fail %v", err)
 		}
 		return nil
@@ -45,3 +172,59 @@ func (s *MemorySubSystem) Remove(cgroupPath string) error {
 		return err
 	}
 }
+
+// 读取内存使用量和内存限制
+func (s *MemorySubSystem) GetStats(cgroupPath string, stats *Stats) error {
+	subsysCgroupPath, err := GetCgroupPath(s.Name(), cgroupPath, false)
+	if err != nil {
+		return err
+	}
+	usageFile, limitFile := "memory.usage_in_bytes", "memory.limit_in_bytes"
+	if IsCgroup2UnifiedMode() {
+		usageFile, limitFile = "memory.current", "memory.max"
+	}
+	if stats.MemoryUsage, err = readUint64(subsysCgroupPath, usageFile); err != nil {
+		return err
+	}
+	if stats.MemoryLimit, err = readUint64(subsysCgroupPath, limitFile); err != nil {
+		return err
+	}
+	// v1不限制时是一个接近int64最大值的数，统一当作不限制
+	if stats.MemoryLimit >= unlimitedMemory {
+		stats.MemoryLimit = 0
+	}
+
+	// 被OOM killer杀死的次数：v1在memory.oom_control中，v2在memory.events中
+	eventsFile := "memory.oom_control"
+	if IsCgroup2UnifiedMode() {
+		eventsFile = "memory.events"
+	}
+	events, err := readKeyValues(subsysCgroupPath, eventsFile)
+	if err != nil {
+		return err
+	}
+	stats.OomKillCount = events["oom_kill"]
+	return nil
+}
+
+// 解析便于阅读的内存大小，如512m、2g；空字符串返回0表示未设置，-1表示不限制
+func parseMemory(value string) (int64, error) {
+	switch value {
+	case "":
+		return 0, nil
+	case "-1":
+		return -1, nil
+	}
+	n, err := utils.RAMInBytes(value)
+	if err != nil {
+		return 0, fmt.Errorf("parse memory %s fail %v", value, err)
+	}
+	return n, nil
+}
+
+func cgroup2MemoryValue(n int64) string {
+	if n == -1 {
+		return "max"
+	}
+	return strconv.FormatInt(n, 10)
+}
@@ -0,0 +1,79 @@
+package subsystems
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"strconv"
+)
+
+// 限制cgroup中的进程数，防止fork炸弹耗尽主机的pid
+type PidsSubSystem struct {
+}
+
+func (s *PidsSubSystem) Name() string {
+	return "pids"
+}
+
+// v1和v2都是写pids.max，小于等于0表示不限制
+func (s *PidsSubSystem) Set(cgroupPath string, res *ResourceConfig) error {
+	if subsysCgroupPath, err := GetCgroupPath(s.Name(), cgroupPath, true); err == nil {
+		if res.PidsLimit == "" {
+			return nil
+		}
+		limit, err := strconv.ParseInt(res.PidsLimit, 10, 64)
+		if err != nil {
+			return fmt.Errorf("parse pids limit %s fail %v", res.PidsLimit, err)
+		}
+		value := "max"
+		if limit > 0 {
+			value = strconv.FormatInt(limit, 10)
+		}
+		if err := ioutil.WriteFile(path.Join(subsysCgroupPath, "pids.max"), []byte(value), 0644); err != nil {
+			return fmt.Errorf("set cgroup pids fail %v", err)
+		}
+		return nil
+	} else {
+		return err
+	}
+}
+
+func (s *PidsSubSystem) Apply(cgroupPath string, pid int) error {
+	if subsysCgroupPath, err := GetCgroupPath(s.Name(), cgroupPath, false); err == nil {
+		if err := ioutil.WriteFile(path.Join(subsysCgroupPath, procsFileName()), []byte(strconv.Itoa(pid)), 0644); err != nil {
+			return fmt.Errorf("set cgroup proc fail %v", err)
+		}
+		return nil
+	} else {
+		return err
+	}
+}
+
+func (s *PidsSubSystem) Remove(cgroupPath string) error {
+	if subsysCgroupPath, err := GetCgroupPath(s.Name(), cgroupPath, false); err == nil {
+		return os.RemoveAll(subsysCgroupPath)
+	} else {
+		return err
+	}
+}
+
+// 读取当前进程数、进程数限制，以及pids.events中因达到上限而fork失败的次数
+func (s *PidsSubSystem) GetStats(cgroupPath string, stats *Stats) error {
+	subsysCgroupPath, err := GetCgroupPath(s.Name(), cgroupPath, false)
+	if err != nil {
+		return err
+	}
+	if stats.PidsCurrent, err = readUint64(subsysCgroupPath, "pids.current"); err != nil {
+		return err
+	}
+	if stats.PidsLimit, err = readUint64(subsysCgroupPath, "pids.max"); err != nil {
+		return err
+	}
+	events, err := readKeyValues(subsysCgroupPath, "pids.events")
+	if err != nil {
+		return err
+	}
+	stats.PidsLimitHits = events["max"]
+	return nil
+}
@@ -1,13 +1,148 @@
 package subsystems
 
+import (
+	"fmt"
+	"strconv"
+)
+
 // 用于传递资源限制
 type ResourceConfig struct {
-	// 内存限制
-	MemoryLimit string
+	// 内存限制，支持512m、2g这类格式
+	MemoryLimit string `json:"memoryLimit,omitempty" yaml:"memoryLimit,omitempty"`
+	// 内存+swap的总限制，-1表示不限制swap
+	MemorySwap string `json:"memorySwap,omitempty" yaml:"memorySwap,omitempty"`
+	// 内存软限制(预留)
+	MemoryReservation string `json:"memoryReservation,omitempty" yaml:"memoryReservation,omitempty"`
+	// 内核内存限制(仅v1)
+	KernelMemory string `json:"kernelMemory,omitempty" yaml:"kernelMemory,omitempty"`
+	// 内存不足时不杀死容器进程(仅v1)，nil表示未设置，保持cgroup的原有配置
+	OomKillDisable *bool `json:"oomKillDisable,omitempty" yaml:"oomKillDisable,omitempty"`
+	// 容器init进程的oom_score_adj，不属于cgroup设置，由run写入/proc/<pid>/oom_score_adj
+	OomScoreAdj string `json:"oomScoreAdj,omitempty" yaml:"oomScoreAdj,omitempty"`
 	// cpu时间片权重限制
-	CpuShare string
+	CpuShare string `json:"cpuShare,omitempty" yaml:"cpuShare,omitempty"`
 	// cpu核心数限制
-	CpuSet string
+	CpuSet string `json:"cpuSet,omitempty" yaml:"cpuSet,omitempty"`
+	// cpu硬限制：可使用的cpu个数，如1.5，与CpuQuota互斥
+	Cpus string `json:"cpus,omitempty" yaml:"cpus,omitempty"`
+	// cfs调度周期(微秒)
+	CpuPeriod string `json:"cpuPeriod,omitempty" yaml:"cpuPeriod,omitempty"`
+	// 每个调度周期内可使用的cpu时间(微秒)，-1表示不限制
+	CpuQuota string `json:"cpuQuota,omitempty" yaml:"cpuQuota,omitempty"`
+	// 块设备io权重(10-1000)
+	BlkioWeight string `json:"blkioWeight,omitempty" yaml:"blkioWeight,omitempty"`
+	// 块设备读写限速，每项格式为 "设备路径:速率"，如 /dev/sda:1mb、/dev/sda:100
+	DeviceReadBps   []string `json:"deviceReadBps,omitempty" yaml:"deviceReadBps,omitempty"`
+	DeviceWriteBps  []string `json:"deviceWriteBps,omitempty" yaml:"deviceWriteBps,omitempty"`
+	DeviceReadIOps  []string `json:"deviceReadIOps,omitempty" yaml:"deviceReadIOps,omitempty"`
+	DeviceWriteIOps []string `json:"deviceWriteIOps,omitempty" yaml:"deviceWriteIOps,omitempty"`
+	// 进程数限制，小于等于0表示不限制
+	PidsLimit string `json:"pidsLimit,omitempty" yaml:"pidsLimit,omitempty"`
+}
+
+// blkio.weight的取值范围，v2的io.weight由此换算
+const (
+	minBlkioWeight = 10
+	maxBlkioWeight = 1000
+)
+
+// Validate 检查无需访问cgroup就能发现的错误参数
+func (r *ResourceConfig) Validate() error {
+	if r.Cpus != "" && r.CpuQuota != "" {
+		return fmt.Errorf("cpus and cpu-quota parameter can not both provided")
+	}
+	if r.BlkioWeight != "" {
+		weight, err := strconv.ParseUint(r.BlkioWeight, 10, 64)
+		if err != nil || weight < minBlkioWeight || weight > maxBlkioWeight {
+			return fmt.Errorf("invalid blkio weight %s, should be in range %d-%d", r.BlkioWeight, minBlkioWeight, maxBlkioWeight)
+		}
+	}
+	return nil
+}
+
+// HasLimits 是否为子系统指定了资源限制，主机上没有该子系统时，指定了限制的不能跳过
+func (r *ResourceConfig) HasLimits(subsystem string) bool {
+	if r == nil {
+		return false
+	}
+	switch subsystem {
+	case "cpu":
+		return r.CpuShare != "" || r.Cpus != "" || r.CpuPeriod != "" || r.CpuQuota != ""
+	case "cpuset":
+		return r.CpuSet != ""
+	case "memory":
+		return r.MemoryLimit != "" || r.MemorySwap != "" || r.MemoryReservation != "" || r.KernelMemory != "" || r.OomKillDisable != nil
+	case "blkio":
+		return r.BlkioWeight != "" || len(r.DeviceReadBps) > 0 || len(r.DeviceWriteBps) > 0 ||
+			len(r.DeviceReadIOps) > 0 || len(r.DeviceWriteIOps) > 0
+	case "pids":
+		return r.PidsLimit != ""
+	}
+	return false
+}
+
+// Merge 将src中指定了的资源限制覆盖到dst
+func (dst *ResourceConfig) Merge(src *ResourceConfig) {
+	mergeString := func(dst *string, src string) {
+		if src != "" {
+			*dst = src
+		}
+	}
+	mergeSlice := func(dst *[]string, src []string) {
+		if len(src) > 0 {
+			*dst = src
+		}
+	}
+
+	mergeString(&dst.MemoryLimit, src.MemoryLimit)
+	mergeString(&dst.MemorySwap, src.MemorySwap)
+	mergeString(&dst.MemoryReservation, src.MemoryReservation)
+	mergeString(&dst.KernelMemory, src.KernelMemory)
+	mergeString(&dst.OomScoreAdj, src.OomScoreAdj)
+	mergeString(&dst.CpuShare, src.CpuShare)
+	mergeString(&dst.CpuSet, src.CpuSet)
+	mergeString(&dst.CpuPeriod, src.CpuPeriod)
+	mergeString(&dst.BlkioWeight, src.BlkioWeight)
+	mergeString(&dst.PidsLimit, src.PidsLimit)
+	mergeSlice(&dst.DeviceReadBps, src.DeviceReadBps)
+	mergeSlice(&dst.DeviceWriteBps, src.DeviceWriteBps)
+	mergeSlice(&dst.DeviceReadIOps, src.DeviceReadIOps)
+	mergeSlice(&dst.DeviceWriteIOps, src.DeviceWriteIOps)
+	if src.OomKillDisable != nil {
+		dst.OomKillDisable = src.OomKillDisable
+	}
+
+	// --cpus和--cpu-quota互斥，指定其中一个时清除另一个
+	if src.Cpus != "" {
+		dst.Cpus, dst.CpuQuota = src.Cpus, ""
+	}
+	if src.CpuQuota != "" {
+		dst.Cpus, dst.CpuQuota = "", src.CpuQuota
+	}
+}
+
+// 从cgroup中读取的资源使用统计
+type Stats struct {
+	// 内存使用量(字节)
+	MemoryUsage uint64 `json:"memoryUsage"`
+	// 内存限制(字节)，0表示不限制
+	MemoryLimit uint64 `json:"memoryLimit"`
+	// 被OOM killer杀死进程的次数
+	OomKillCount uint64 `json:"oomKillCount"`
+	// 累计使用的cpu时间(纳秒)
+	CpuUsage uint64 `json:"cpuUsage"`
+	// 经历的cfs调度周期数
+	CpuNrPeriods uint64 `json:"cpuNrPeriods"`
+	// 因用完quota而被限流的周期数
+	CpuNrThrottled uint64 `json:"cpuNrThrottled"`
+	// 累计被限流的时间(纳秒)
+	CpuThrottledTime uint64 `json:"cpuThrottledTime"`
+	// cgroup中的进程数
+	PidsCurrent uint64 `json:"pidsCurrent"`
+	// 进程数限制，0表示不限制
+	PidsLimit uint64 `json:"pidsLimit"`
+	// 因达到进程数限制而fork失败的次数(pids.events中的max)
+	PidsLimitHits uint64 `json:"pidsLimitHits"`
 }
 
 // 将cgroup抽象为path
@@ -16,13 +151,19 @@ type SubSystem interface {
 	Set(path string, res *ResourceConfig) error
 	Apply(path string, pid int) error
 	Remove(path string) error
+	// 读取子系统的统计信息并填入stats，没有统计信息的子系统直接返回nil
+	GetStats(path string, stats *Stats) error
 }
 
 // 定义一个全局的subsystem
 var (
 	SubSystemIns = []SubSystem{
 		&CpuSubSystem{},
+		&CpuacctSubSystem{},
 		&CpusetSubSystem{},
 		&MemorySubSystem{},
+		&BlkioSubSystem{},
+		&PidsSubSystem{},
+		&FreezerSubSystem{},
 	}
 )
@@ -2,12 +2,83 @@ package subsystems
 
 import (
 	"bufio"
+	"errors"
 	"fmt"
+	"golang.org/x/sys/unix"
 	"os"
 	"path"
+	"strconv"
 	"strings"
+	"sync"
 )
 
+const (
+	// cgroup 文件系统的默认挂载点
+	cgroupMountpoint = "/sys/fs/cgroup"
+	// v1的memory.limit_in_bytes不限制时为按页对齐的int64最大值
+	unlimitedMemory = 1 << 62
+)
+
+// CgroupMode 表示主机上cgroup的挂载方式
+type CgroupMode int
+
+const (
+	CgroupModeUnknown CgroupMode = iota
+	// 只挂载了cgroup v1
+	CgroupModeLegacy
+	// cgroup v1的各个子系统 + /sys/fs/cgroup/unified 下的cgroup v2，控制器仍然在v1上
+	CgroupModeHybrid
+	// /sys/fs/cgroup 直接挂载cgroup v2
+	CgroupModeUnified
+)
+
+func (m CgroupMode) String() string {
+	switch m {
+	case CgroupModeLegacy:
+		return "legacy"
+	case CgroupModeHybrid:
+		return "hybrid"
+	case CgroupModeUnified:
+		return "unified"
+	default:
+		return "unknown"
+	}
+}
+
+// ErrSubsystemUnavailable 主机没有挂载(v1)或父cgroup中没有这个控制器(v2)，设置时跳过该子系统
+var ErrSubsystemUnavailable = errors.New("cgroup subsystem not available")
+
+var (
+	cgroupMode CgroupMode
+	modeOnce   sync.Once
+)
+
+// GetCgroupMode 通过statfs判断主机的cgroup挂载方式，结果只检测一次
+func GetCgroupMode() CgroupMode {
+	modeOnce.Do(func() {
+		var st unix.Statfs_t
+		if err := unix.Statfs(cgroupMountpoint, &st); err != nil {
+			cgroupMode = CgroupModeUnknown
+			return
+		}
+		if st.Type == unix.CGROUP2_SUPER_MAGIC {
+			cgroupMode = CgroupModeUnified
+			return
+		}
+		if err := unix.Statfs(path.Join(cgroupMountpoint, "unified"), &st); err == nil && st.Type == unix.CGROUP2_SUPER_MAGIC {
+			cgroupMode = CgroupModeHybrid
+			return
+		}
+		cgroupMode = CgroupModeLegacy
+	})
+	return cgroupMode
+}
+
+// IsCgroup2UnifiedMode 是否需要使用cgroup v2驱动。hybrid模式下控制器都挂在v1上，仍然按v1处理
+func IsCgroup2UnifiedMode() bool {
+	return GetCgroupMode() == CgroupModeUnified
+}
+
 func FindCgroupMountPoint(subsystem string) string {
 	f, err := os.Open("/proc/self/mountinfo")
 	if err != nil {
@@ -31,16 +102,76 @@ func FindCgroupMountPoint(subsystem string) string {
 }
 
 func GetCgroupPath(subsystem string, cgroupPath string, autoCreate bool) (string, error) {
+	if IsCgroup2UnifiedMode() {
+		return getCgroup2Path(subsystem, cgroupPath, autoCreate)
+	}
+
 	var err error
 	cgroupRoot := FindCgroupMountPoint(subsystem)
+	if cgroupRoot == "" {
+		return "", fmt.Errorf("%w: %s is not mounted", ErrSubsystemUnavailable, subsystem)
+	}
 	if _, err = os.Stat(path.Join(cgroupRoot, cgroupPath)); err == nil || (autoCreate && os.IsNotExist(err)) {
 		// 如果报文件或目录不存在，则执行mkdir
 		if os.IsNotExist(err) {
-			if err = os.Mkdir(path.Join(cgroupRoot, cgroupPath), 0755); err != nil {
+			if err = os.MkdirAll(path.Join(cgroupRoot, cgroupPath), 0755); err != nil {
 				return "", fmt.Errorf("error create cgroup %v", err)
 			}
 		}
 		return path.Join(cgroupRoot, cgroupPath), nil
 	}
-	return "", fmt.Errorf("cgroup path error %v", err)
+	return "", fmt.Errorf("cgroup path error %w", err)
+}
+
+// 将进程加入cgroup时写入的文件：v1写tasks，v2只有cgroup.procs
+func procsFileName() string {
+	if IsCgroup2UnifiedMode() {
+		return "cgroup.procs"
+	}
+	return "tasks"
+}
+
+// 读取只包含一个数值的cgroup文件，v2中的"max"表示不限制，返回0
+func readUint64(dir, file string) (uint64, error) {
+	content, err := os.ReadFile(path.Join(dir, file))
+	if err != nil {
+		return 0, fmt.Errorf("read %s error %v", path.Join(dir, file), err)
+	}
+	value := strings.TrimSpace(string(content))
+	if value == "max" {
+		return 0, nil
+	}
+	n, err := strconv.ParseUint(value, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("parse %s value %q error %v", path.Join(dir, file), value, err)
+	}
+	return n, nil
+}
+
+// 读取"key value"格式的cgroup文件，如cpu.stat、memory.events、pids.events
+func readKeyValues(dir, file string) (map[string]uint64, error) {
+	content, err := os.ReadFile(path.Join(dir, file))
+	if err != nil {
+		return nil, fmt.Errorf("read %s error %v", path.Join(dir, file), err)
+	}
+	values := map[string]uint64{}
+	for _, line := range strings.Split(string(content), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			continue
+		}
+		n, err := strconv.ParseUint(fields[1], 10, 64)
+		if err != nil {
+			continue
+		}
+		values[fields[0]] = n
+	}
+	return values, nil
+}
+
+func writeCgroupFile(dir, file, value string) error {
+	if err := os.WriteFile(path.Join(dir, file), []byte(value), 0644); err != nil {
+		return fmt.Errorf("set cgroup %s fail %v", file, err)
+	}
+	return nil
 }
@@ -7,3 +7,16 @@ func TestFindCgroupMountPoint(t *testing.T) {
 	t.Logf("cpuset subsystem mount point %v\n", FindCgroupMountPoint("cpuset"))
 	t.Logf("memory subsystem mount point %v\n", FindCgroupMountPoint("memory"))
 }
+
+func TestGetCgroupMode(t *testing.T) {
+	t.Logf("cgroup mode %v\n", GetCgroupMode())
+}
+
+func TestConvertCPUSharesToCgroupV2Value(t *testing.T) {
+	cases := map[uint64]uint64{0: 0, 1: 1, 2: 1, 1024: 39, 262144: 10000, 300000: 10000}
+	for shares, want := range cases {
+		if got := convertCPUSharesToCgroupV2Value(shares); got != want {
+			t.Errorf("shares %d: want weight %d, got %d", shares, want, got)
+		}
+	}
+}
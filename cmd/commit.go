@@ -1,16 +1,99 @@
 package cmd
 
 import (
+	"fmt"
 	log "github.com/sirupsen/logrus"
+	"io"
+	"mydocker/cgroups"
+	"mydocker/cgroups/subsystems"
+	"mydocker/image"
 	"mydocker/vars"
-	"os/exec"
-	"path"
+	"reflect"
 )
 
-func CommitContainer(containerName, imageName string) {
-	mntPath := path.Join(vars.MntDir, containerName)
-	imageTarPath := path.Join(vars.ImagesDir, imageName+".tar")
-	if _, err := exec.Command("tar", "-zcf", imageTarPath, "-C", mntPath, ".").CombinedOutput(); err != nil {
-		log.Errorf("commit %s error: %v", containerName, err)
+// CommitOptions commit时记录到新镜像config中的信息
+type CommitOptions struct {
+	Author  string
+	Message string
+}
+
+// CommitContainer 将容器upperLayer中的改动作为新的一层叠加到容器的镜像上，生成新镜像，imageName不为空时打上标签
+func CommitContainer(containerName, imageName string, options CommitOptions) {
+	containerInfo, err := getContainerInfo(containerName)
+	if err != nil {
+		log.Errorf("Get container %s info error %v", containerName, err)
+		return
+	}
+	if containerInfo.Rootfs != "" {
+		log.Errorf("Container %s uses rootfs %s directly, commit is not supported", containerName, containerInfo.Rootfs)
+		return
+	}
+	parentID := containerImageID(containerName)
+	if parentID == "" {
+		log.Errorf("Container %s has not been started", containerName)
+		return
+	}
+	parent, err := image.Get(parentID)
+	if err != nil {
+		log.Errorf("Get image of container %s error %v", containerName, err)
+		return
+	}
+
+	// 与docker一样，打包期间冻结运行中的容器，得到一致的文件系统
+	if containerInfo.Status == vars.RUNNING {
+		manager := cgroups.NewCgroupManager(containerInfo.CgroupPath)
+		if err := manager.Freeze(subsystems.Frozen); err != nil {
+			log.Errorf("Pause container %s error %v", containerName, err)
+			return
+		}
+		defer func() {
+			if err := manager.Freeze(subsystems.Thawed); err != nil {
+				log.Errorf("Unpause container %s error %v", containerName, err)
+			}
+		}()
+	}
+
+	// 打包与导入同时进行，upperLayer的内容不经过临时的tar包
+	reader, writer := io.Pipe()
+	go func() {
+		writer.CloseWithError(image.TarDiff(fmt.Sprintf(vars.UpperDir, containerName), writer))
+	}()
+	layer, err := image.ImportLayer(reader)
+	reader.Close()
+	if err != nil {
+		log.Errorf("Commit container %s error %v", containerName, err)
+		return
+	}
+
+	config := image.NewConfig()
+	config.Author = options.Author
+	config.Config = parent.Config.Config
+	// 容器命令中包含了镜像的Entrypoint，新镜像仍然保留Entrypoint，Cmd中去掉这部分
+	config.Config.Cmd = containerInfo.Args
+	if entrypoint := parent.Config.Config.Entrypoint; len(entrypoint) > 0 && len(containerInfo.Args) >= len(entrypoint) &&
+		reflect.DeepEqual(containerInfo.Args[:len(entrypoint)], entrypoint) {
+		config.Config.Cmd = containerInfo.Args[len(entrypoint):]
+	}
+	config.Config.Env = containerInfo.Env
+	config.Config.WorkingDir = containerInfo.Workdir
+	config.Config.User = containerInfo.User
+	config.RootFS.DiffIDs = append(append([]string{}, parent.Config.RootFS.DiffIDs...), layer.DiffID)
+	config.History = append(append([]image.History{}, parent.Config.History...), image.History{
+		Created:   config.Created,
+		CreatedBy: containerInfo.Command,
+		Author:    options.Author,
+		Comment:   options.Message,
+	})
+	id, err := image.Create(config)
+	if err != nil {
+		log.Errorf("Commit container %s error %v", containerName, err)
+		return
+	}
+	if imageName != "" {
+		if err := image.Tag(imageName, id); err != nil {
+			log.Errorf("Tag image %s error %v", imageName, err)
+			return
+		}
 	}
+	fmt.Println(id)
 }
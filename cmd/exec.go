@@ -13,6 +13,12 @@ import (
 )
 
 func ExecContainer(containerName string, cmdArray []string) {
+	// 被冻结的容器中无法运行新进程，nsenter会一直卡住
+	if containerInfo, err := getContainerInfo(containerName); err == nil && containerInfo.Status == vars.PAUSED {
+		log.Errorf("Container %s is paused, unpause it first", containerName)
+		return
+	}
+
 	pid, err := getContainerPidByName(containerName)
 	if err != nil {
 		log.Errorf("Exec container getContainerPidByName %s error %v", containerName, err)
@@ -0,0 +1,235 @@
+package cmd
+
+import (
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"mydocker/image"
+	"mydocker/utils"
+	"mydocker/vars"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// ListImages 列出镜像存储中的镜像，一个镜像有多个标签时每个标签一行，没有标签的镜像显示为<none>
+func ListImages() {
+	ids, err := image.List()
+	if err != nil {
+		log.Errorf("List images error %v", err)
+		return
+	}
+
+	type imageRow struct {
+		repo, tag, id, created string
+		createdAt              time.Time
+		size                   int64
+	}
+	var rows []imageRow
+	for _, id := range ids {
+		img, err := image.Get(id)
+		if err != nil {
+			log.Errorf("Get image %s error %v", id, err)
+			continue
+		}
+		var size int64
+		for _, layer := range img.Manifest.Layers {
+			size += layer.Size
+		}
+		createdAt, _ := time.Parse(time.RFC3339Nano, img.Config.Created)
+		row := imageRow{id: image.ShortID(id), createdAt: createdAt, size: size}
+		if !createdAt.IsZero() {
+			row.created = createdAt.Local().Format(vars.TimeFormat)
+		}
+
+		tags, err := image.Tags(id)
+		if err != nil {
+			log.Errorf("Get image %s tags error %v", id, err)
+			continue
+		}
+		if len(tags) == 0 {
+			row.repo, row.tag = "<none>", "<none>"
+			rows = append(rows, row)
+		}
+		for _, name := range tags {
+			row.repo, row.tag = image.ParseReference(name)
+			rows = append(rows, row)
+		}
+	}
+	// 最新的镜像在前
+	sort.SliceStable(rows, func(i, j int) bool {
+		if !rows[i].createdAt.Equal(rows[j].createdAt) {
+			return rows[i].createdAt.After(rows[j].createdAt)
+		}
+		return rows[i].repo+":"+rows[i].tag < rows[j].repo+":"+rows[j].tag
+	})
+
+	w := tabwriter.NewWriter(os.Stdout, 12, 1, 3, ' ', 0)
+	fmt.Fprintf(w, "REPOSITORY\tTAG\tIMAGE ID\tCREATED\tSIZE\n")
+	for _, row := range rows {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", row.repo, row.tag, row.id, row.created, utils.BytesSize(float64(row.size)))
+	}
+	if err := w.Flush(); err != nil {
+		log.Errorf("Flush error: %v", err)
+	}
+}
+
+// LoadImage 导入docker save的tar包或OCI image-layout，导入的镜像可以按name:tag运行
+func LoadImage(input string) {
+	loaded, err := image.Load(input)
+	if err != nil {
+		log.Errorf("Load image from %s error %v", input, err)
+		return
+	}
+	for _, img := range loaded {
+		if len(img.Names) == 0 {
+			fmt.Printf("Loaded image ID: %s\n", img.ID)
+		}
+		for _, name := range img.Names {
+			fmt.Printf("Loaded image: %s\n", name)
+		}
+	}
+}
+
+// TagImage 为镜像source添加别名target
+func TagImage(source, target string) {
+	id, err := image.Resolve(source)
+	if err != nil {
+		log.Errorf("Tag image error %v", err)
+		return
+	}
+	if err := image.Tag(target, id); err != nil {
+		log.Errorf("Tag image %s as %s error %v", source, target, err)
+	}
+}
+
+// RemoveImage 删除镜像：按name:tag删除且镜像还有其他标签时只删除该标签；
+// 否则删除镜像及其不再使用的镜像层，有容器使用该镜像时需要-f
+func RemoveImage(ref string, force bool) {
+	id, err := image.Lookup(ref)
+	if err != nil {
+		log.Errorf("Remove image %s error %v", ref, err)
+		return
+	}
+	if id == "" {
+		log.Errorf("No such image %s", ref)
+		return
+	}
+	tags, err := image.Tags(id)
+	if err != nil {
+		log.Errorf("Remove image %s error %v", ref, err)
+		return
+	}
+
+	byName := false
+	for _, name := range tags {
+		if name == image.NormalizeName(ref) {
+			byName = true
+		}
+	}
+	if byName && len(tags) > 1 {
+		if _, err := image.Untag(ref); err != nil {
+			log.Errorf("Untag image %s error %v", ref, err)
+			return
+		}
+		fmt.Printf("Untagged: %s\n", image.NormalizeName(ref))
+		return
+	}
+	if !byName && len(tags) > 1 && !force {
+		log.Errorf("Image %s is referenced in multiple repositories %s, use -f to remove", ref, strings.Join(tags, ", "))
+		return
+	}
+
+	users, err := imageContainers(id)
+	if err != nil {
+		log.Errorf("Remove image %s error %v", ref, err)
+		return
+	}
+	if len(users) > 0 && !force {
+		log.Errorf("Image %s is being used by container %s, use -f to remove", ref, strings.Join(users, ", "))
+		return
+	}
+
+	if err := image.Delete(id); err != nil {
+		log.Errorf("Remove image %s error %v", ref, err)
+		return
+	}
+	for _, name := range tags {
+		fmt.Printf("Untagged: %s\n", name)
+	}
+	fmt.Printf("Deleted: %s\n", id)
+
+	// 强制删除时，容器仍在使用的镜像层保留到容器删除
+	for _, diffID := range removeDanglingLayers() {
+		fmt.Printf("Deleted: %s\n", diffID)
+	}
+}
+
+// 删除不再被镜像或容器使用的镜像层
+func removeDanglingLayers() []string {
+	inUse, err := containerLayers()
+	if err != nil {
+		log.Errorf("Get layers used by containers error %v", err)
+		return nil
+	}
+	removed, err := image.RemoveUnusedLayers(inUse)
+	if err != nil {
+		log.Errorf("Remove unused layers error %v", err)
+	}
+	return removed
+}
+
+// 容器创建时解析到的镜像ID，记录在lowerLayer目录下
+func containerImageID(containerName string) string {
+	content, err := os.ReadFile(path.Join(fmt.Sprintf(vars.LowerDir, containerName), "image"))
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(content))
+}
+
+// 使用镜像的容器
+func imageContainers(id string) ([]string, error) {
+	containers, err := os.ReadDir(vars.ContainersRootPath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("read %s error %v", vars.ContainersRootPath, err)
+	}
+	var names []string
+	for _, c := range containers {
+		if containerImageID(c.Name()) == id {
+			names = append(names, c.Name())
+		}
+	}
+	return names, nil
+}
+
+// 容器的overlay正在使用的镜像层，从lowerLayer目录下记录的lowerdir得到
+func containerLayers() (map[string]bool, error) {
+	containers, err := os.ReadDir(vars.ContainersRootPath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("read %s error %v", vars.ContainersRootPath, err)
+	}
+	layers := map[string]bool{}
+	for _, c := range containers {
+		content, err := os.ReadFile(path.Join(fmt.Sprintf(vars.LowerDir, c.Name()), "lower"))
+		if err != nil {
+			continue
+		}
+		for _, link := range strings.Split(strings.TrimSpace(string(content)), ":") {
+			diffID, err := image.LinkLayer(link)
+			if err != nil {
+				return nil, err
+			}
+			layers[diffID] = true
+		}
+	}
+	return layers, nil
+}
@@ -0,0 +1,40 @@
+package cmd
+
+import (
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"mydocker/utils"
+	"strconv"
+	"syscall"
+)
+
+// KillContainer 向容器的init进程发送指定信号，如SIGHUP通知应用重新加载配置
+func KillContainer(containerName, signal string) {
+	if err := killContainer(containerName, signal); err != nil {
+		log.Errorf("Kill container %s error %v", containerName, err)
+	}
+}
+
+func killContainer(containerName, signal string) error {
+	sig, err := utils.ParseSignal(signal)
+	if err != nil {
+		return err
+	}
+
+	pid, err := getContainerPidByName(containerName)
+	if err != nil {
+		return fmt.Errorf("get container pid error %v", err)
+	}
+	if pid == "" {
+		return fmt.Errorf("container %s is not running", containerName)
+	}
+
+	pidInt, err := strconv.Atoi(pid)
+	if err != nil {
+		return fmt.Errorf("conver pid from string to int error %v", err)
+	}
+	if err := syscall.Kill(pidInt, sig); err != nil {
+		return fmt.Errorf("send signal %s error %v", signal, err)
+	}
+	return nil
+}
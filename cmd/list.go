@@ -4,10 +4,13 @@ import (
 	"encoding/json"
 	"fmt"
 	log "github.com/sirupsen/logrus"
+	"mydocker/cgroups"
 	"mydocker/container"
 	"mydocker/vars"
 	"os"
 	"path"
+	"strconv"
+	"syscall"
 	"text/tabwriter"
 )
 
@@ -25,19 +28,22 @@ func ListContainers() {
 			log.Errorf("Get container info error %v", err)
 			continue
 		}
+		syncContainerStatus(tmpContainerInfo)
 		containersInfo = append(containersInfo, tmpContainerInfo)
 	}
 	w := tabwriter.NewWriter(os.Stdout, 12, 1, 3, ' ', 0)
-	fmt.Fprintf(w, "ID\tNAME\tPID\tSTATUS\tCOMMAND\tCREATED\n")
+	fmt.Fprintf(w, "ID\tNAME\tPID\tSTATUS\tCOMMAND\tCREATED\tRESTARTS\tPIDS-LIMIT-HITS\n")
 
 	for _, item := range containersInfo {
-		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\n",
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\t%d\t%s\n",
 			item.Id,
 			item.Name,
 			item.Pid,
-			item.Status,
+			displayStatus(item),
 			item.Command,
 			item.CreatedTime,
+			item.RestartCount,
+			getPidsLimitHits(item),
 		)
 	}
 	if err := w.Flush(); err != nil {
@@ -64,3 +70,84 @@ func getContainerInfo(containerName string) (*container.ContainerInfo, error) {
 
 	return containerInfo, nil
 }
+
+// 读取容器因达到进程数限制而fork失败的次数，cgroup不存在时显示"-"
+func getPidsLimitHits(containerInfo *container.ContainerInfo) string {
+	if containerInfo.CgroupPath == "" {
+		return "-"
+	}
+	stats, err := cgroups.NewCgroupManager(containerInfo.CgroupPath).GetStats()
+	if err != nil {
+		return "-"
+	}
+	return strconv.FormatUint(stats.PidsLimitHits, 10)
+}
+
+// 以容器进程的实际状态为准：记录为running/paused的容器，其进程已经不存在时，
+// 若本次启动后cgroup中发生过OOM kill则记为exited(137)，否则退出码已经无法得知，记为dead
+func syncContainerStatus(containerInfo *container.ContainerInfo) {
+	// 等待重启时shim已经不在了(如被kill)，不会再重启，保留上一次的退出码
+	if containerInfo.Status == vars.RESTARTING {
+		if containerInfo.ShimPid == "" || !container.IsProcessAlive(containerInfo.ShimPid) {
+			containerInfo.Status = vars.EXIT
+			containerInfo.ShimPid = ""
+			if err := updateContainerInfo(containerInfo); err != nil {
+				log.Errorf("Update container %s info error %v", containerInfo.Name, err)
+			}
+		}
+		return
+	}
+	if !containerInfo.IsRunning() || container.IsProcessAlive(containerInfo.Pid) {
+		return
+	}
+	// shim仍在运行时由shim负责记录退出码
+	if containerInfo.ShimPid != "" && container.IsProcessAlive(containerInfo.ShimPid) {
+		return
+	}
+
+	if oomKillCount(containerInfo) > containerInfo.OOMKillBase {
+		containerInfo.SetExited(128 + int(syscall.SIGKILL))
+		containerInfo.OOMKilled = true
+	} else {
+		containerInfo.SetDead()
+	}
+	if err := updateContainerInfo(containerInfo); err != nil {
+		log.Errorf("Update container %s info error %v", containerInfo.Name, err)
+	}
+}
+
+// 容器cgroup中累计的OOM kill次数，cgroup不存在时为0
+func oomKillCount(containerInfo *container.ContainerInfo) uint64 {
+	if containerInfo.CgroupPath == "" {
+		return 0
+	}
+	stats, err := cgroups.NewCgroupManager(containerInfo.CgroupPath).GetStats()
+	if err != nil {
+		return 0
+	}
+	return stats.OomKillCount
+}
+
+func displayStatus(containerInfo *container.ContainerInfo) string {
+	if containerInfo.Status != vars.EXIT && containerInfo.Status != vars.RESTARTING {
+		return containerInfo.Status
+	}
+	if containerInfo.OOMKilled {
+		return containerInfo.Status + " (OOMKilled)"
+	}
+	return fmt.Sprintf("%s (%d)", containerInfo.Status, containerInfo.ExitCode)
+}
+
+// 将容器信息写回config.json
+func updateContainerInfo(containerInfo *container.ContainerInfo) error {
+	newContentBytes, err := json.Marshal(containerInfo)
+	if err != nil {
+		return fmt.Errorf("json marshal %s error %v", containerInfo.Name, err)
+	}
+
+	configFilePath := path.Join(fmt.Sprintf(vars.DefaultInfoLocation, containerInfo.Name), vars.ConfigName)
+	if err := os.WriteFile(configFilePath, newContentBytes, 0622); err != nil {
+		return fmt.Errorf("write file %s error %v", configFilePath, err)
+	}
+	return nil
+}
@@ -0,0 +1,212 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"golang.org/x/sys/unix"
+	"io"
+	"mydocker/cgroups"
+	"mydocker/cgroups/subsystems"
+	"mydocker/container"
+	"mydocker/vars"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"syscall"
+)
+
+/*
+	oci子命令按照runc的方式管理OCI bundle：
+	create: 创建容器，init进程完成挂载等准备工作后阻塞在容器目录下的exec.fifo上
+	start:  读取exec.fifo，init进程exec用户命令
+	state:  输出OCI格式的容器状态
+	kill:   向容器init进程发送信号
+	delete: 删除已停止(或created)的容器
+	run:    create + start
+	这些命令出错时返回错误，命令以非0退出码退出，便于上层工具判断
+*/
+
+// 读取bundle的config.json并转换为Spec
+func loadOCISpec(id, bundle string) (*container.Spec, error) {
+	ociSpec, err := container.LoadOCIBundle(bundle)
+	if err != nil {
+		return nil, err
+	}
+	spec, err := ociSpec.ToSpec(id, bundle)
+	if err != nil {
+		return nil, err
+	}
+	return spec, spec.Validate()
+}
+
+// 根据spec创建容器记录，并记录bundle目录
+func createOCIContainer(spec *container.Spec, bundle string) (*container.ContainerInfo, error) {
+	containerInfo, err := createContainer(spec)
+	if err != nil {
+		return nil, err
+	}
+	containerInfo.Bundle = bundle
+	return containerInfo, updateContainerInfo(containerInfo)
+}
+
+// OCICreate 创建容器，但不运行用户命令
+func OCICreate(id, bundle string) error {
+	bundle, err := filepath.Abs(bundle)
+	if err != nil {
+		return err
+	}
+	spec, err := loadOCISpec(id, bundle)
+	if err != nil {
+		return err
+	}
+	if spec.Tty {
+		return fmt.Errorf("process.terminal is only supported by oci run")
+	}
+	containerInfo, err := createOCIContainer(spec, bundle)
+	if err != nil {
+		return err
+	}
+
+	fifo := path.Join(fmt.Sprintf(vars.DefaultInfoLocation, id), "exec.fifo")
+	if err := unix.Mkfifo(fifo, 0622); err != nil {
+		return fmt.Errorf("create exec fifo error %v", err)
+	}
+	containerInfo.ExecFifo = fifo
+	if err := updateContainerInfo(containerInfo); err != nil {
+		return err
+	}
+	return startShim(id)
+}
+
+// OCIStart 运行created状态容器的用户命令
+func OCIStart(id string) error {
+	containerInfo, err := getContainerInfo(id)
+	if err != nil {
+		return err
+	}
+	if containerInfo.Status != vars.CREATED || containerInfo.ExecFifo == "" {
+		return fmt.Errorf("container %s is %s, not created", id, containerInfo.Status)
+	}
+	if !container.IsProcessAlive(containerInfo.Pid) {
+		return fmt.Errorf("container %s init process is not running", id)
+	}
+
+	// 先记录为running，打开fifo后init进程马上exec，用户命令可能很快退出，shim会记录退出状态
+	fifo := containerInfo.ExecFifo
+	pid, _ := strconv.Atoi(containerInfo.Pid)
+	containerInfo.SetRunning(pid)
+	containerInfo.ExecFifo = ""
+	if err := updateContainerInfo(containerInfo); err != nil {
+		return err
+	}
+
+	f, err := os.OpenFile(fifo, os.O_RDONLY, 0)
+	if err != nil {
+		return fmt.Errorf("open exec fifo error %v", err)
+	}
+	defer os.Remove(fifo)
+	defer f.Close()
+	data, err := io.ReadAll(f)
+	if err != nil {
+		return fmt.Errorf("read exec fifo error %v", err)
+	}
+	if len(data) == 0 {
+		return fmt.Errorf("container %s init process exited before start", id)
+	}
+	return nil
+}
+
+// OCIState 以OCI格式输出容器状态
+func OCIState(id string) error {
+	containerInfo, err := getContainerInfo(id)
+	if err != nil {
+		return err
+	}
+	syncContainerStatus(containerInfo)
+
+	state := &container.OCIState{
+		OCIVersion: container.OCIVersion,
+		ID:         id,
+		Bundle:     containerInfo.Bundle,
+	}
+	switch containerInfo.Status {
+	case vars.CREATED, vars.RUNNING, vars.PAUSED:
+		state.Status = containerInfo.Status
+		state.Pid, _ = strconv.Atoi(containerInfo.Pid)
+	default:
+		state.Status = "stopped"
+	}
+	if containerInfo.Bundle != "" {
+		if ociSpec, err := container.LoadOCIBundle(containerInfo.Bundle); err == nil {
+			state.Annotations = ociSpec.Annotations
+		}
+	}
+
+	content, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return err
+	}
+	fmt.Println(string(content))
+	return nil
+}
+
+// OCIKill 向容器的init进程发送信号
+func OCIKill(id, signal string) error {
+	return killContainer(id, signal)
+}
+
+// OCIDelete 删除容器，created状态的容器会被直接杀死，运行中的容器需要force
+func OCIDelete(id string, force bool) error {
+	containerInfo, err := getContainerInfo(id)
+	if err != nil {
+		return err
+	}
+	syncContainerStatus(containerInfo)
+
+	if container.IsProcessAlive(containerInfo.Pid) {
+		if containerInfo.Status != vars.CREATED && !force {
+			return fmt.Errorf("container %s is %s, stop it first or use --force", id, containerInfo.Status)
+		}
+		// 暂停中的容器进程被冻结，需要先解冻，否则SIGKILL无法被处理
+		if containerInfo.Status == vars.PAUSED {
+			if err := cgroups.NewCgroupManager(containerInfo.CgroupPath).Freeze(subsystems.Thawed); err != nil {
+				return fmt.Errorf("unpause container %s error %v", id, err)
+			}
+		}
+		pid, _ := strconv.Atoi(containerInfo.Pid)
+		if err := syscall.Kill(pid, syscall.SIGKILL); err != nil {
+			return fmt.Errorf("kill container %s error %v", id, err)
+		}
+		if err := waitContainerStopped(id, killTimeout); err != nil {
+			return err
+		}
+	}
+
+	RemoveContainer(id)
+	if exist, _ := container.PathExists(fmt.Sprintf(vars.DefaultInfoLocation, id)); exist {
+		return fmt.Errorf("remove container %s failed", id)
+	}
+	return nil
+}
+
+// OCIRun 创建并运行容器，detach时交给shim在后台运行，否则在前台运行，退出后删除容器
+func OCIRun(id, bundle string, detach bool) error {
+	bundle, err := filepath.Abs(bundle)
+	if err != nil {
+		return err
+	}
+	spec, err := loadOCISpec(id, bundle)
+	if err != nil {
+		return err
+	}
+	containerInfo, err := createOCIContainer(spec, bundle)
+	if err != nil {
+		return err
+	}
+	if detach {
+		return startShim(id)
+	}
+	runForeground(containerInfo)
+	return nil
+}
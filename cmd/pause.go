@@ -0,0 +1,52 @@
+package cmd
+
+import (
+	log "github.com/sirupsen/logrus"
+	"mydocker/cgroups"
+	"mydocker/cgroups/subsystems"
+	"mydocker/vars"
+)
+
+// PauseContainer 通过freezer冻结容器cgroup中的所有进程
+func PauseContainer(containerName string) {
+	containerInfo, err := getContainerInfo(containerName)
+	if err != nil {
+		log.Errorf("Get container %s info error %v", containerName, err)
+		return
+	}
+	if containerInfo.Status != vars.RUNNING {
+		log.Errorf("Container %s is %s, only running container can be paused", containerName, containerInfo.Status)
+		return
+	}
+
+	if err := cgroups.NewCgroupManager(containerInfo.CgroupPath).Freeze(subsystems.Frozen); err != nil {
+		log.Errorf("Pause container %s error %v", containerName, err)
+		return
+	}
+	containerInfo.Status = vars.PAUSED
+	if err := updateContainerInfo(containerInfo); err != nil {
+		log.Errorf("Update container %s info error %v", containerName, err)
+	}
+}
+
+// UnpauseContainer 解冻被pause的容器
+func UnpauseContainer(containerName string) {
+	containerInfo, err := getContainerInfo(containerName)
+	if err != nil {
+		log.Errorf("Get container %s info error %v", containerName, err)
+		return
+	}
+	if containerInfo.Status != vars.PAUSED {
+		log.Errorf("Container %s is not paused", containerName)
+		return
+	}
+
+	if err := cgroups.NewCgroupManager(containerInfo.CgroupPath).Freeze(subsystems.Thawed); err != nil {
+		log.Errorf("Unpause container %s error %v", containerName, err)
+		return
+	}
+	containerInfo.Status = vars.RUNNING
+	if err := updateContainerInfo(containerInfo); err != nil {
+		log.Errorf("Update container %s info error %v", containerName, err)
+	}
+}
@@ -3,7 +3,7 @@ package cmd
 import (
 	"fmt"
 	log "github.com/sirupsen/logrus"
-	"mydocker/container"
+	"mydocker/cgroups"
 	"mydocker/vars"
 	"os"
 )
@@ -15,17 +15,42 @@ func RemoveContainer(containerName string) {
 		return
 	}
 
-	if containerInfo.Status == vars.RUNNING {
+	// config.json中的状态可能已经过时(如没有shim时容器进程已经退出)
+	syncContainerStatus(containerInfo)
+	if containerInfo.IsRunning() {
 		log.Errorf("Could not remove running container")
+		return
+	}
+	if containerInfo.Status == vars.RESTARTING {
+		log.Errorf("Could not remove restarting container, stop it first")
+		return
+	}
+
+	containerInfo.Status = vars.REMOVING
+	if err := updateContainerInfo(containerInfo); err != nil {
+		log.Errorf("Update container %s info error %v", containerName, err)
+	}
+
+	// 释放容器的ip
+	disconnectNetwork(containerInfo)
+
+	// 销毁容器的cgroup
+	if containerInfo.CgroupPath != "" {
+		cgroups.NewCgroupManager(containerInfo.CgroupPath).Destory()
 	}
 
 	// 移除挂载
-	container.DeleteWorkSpace(containerName, containerInfo.Volume)
+	removeWorkSpace(containerInfo)
 
 	dirUrl := fmt.Sprintf(vars.DefaultInfoLocation, containerName)
 	// 容器的运行目录都应该放在这个目录下面，这样就能完全清理干净。涉及overlay文件系统的umount和remove
 	if err := os.RemoveAll(dirUrl); err != nil {
 		log.Errorf("Remove file %s error %v", dirUrl, err)
+		// 清理失败，标记为dead，之后可以再次rm
+		containerInfo.SetDead()
+		if err := updateContainerInfo(containerInfo); err != nil {
+			log.Errorf("Update container %s info error %v", containerName, err)
+		}
 		return
 	}
 
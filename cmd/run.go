@@ -1,163 +1,349 @@
 package cmd
 
 import (
-	"encoding/json"
 	"fmt"
 	log "github.com/sirupsen/logrus"
 	"math/rand"
 	"mydocker/cgroups"
 	"mydocker/cgroups/subsystems"
 	"mydocker/container"
+	"mydocker/image"
 	"mydocker/network"
 	"mydocker/vars"
 	"os"
+	"os/exec"
 	"path"
 	"strconv"
 	"strings"
 	"time"
 )
 
-// 启动容器时，增加资源限制
-func Run(tty bool, commandArray []string, res *subsystems.ResourceConfig, volume, containerName string, env []string, networkName string, portMapping []string) {
+// 按照spec创建并启动容器
+func Run(spec *container.Spec) {
+	containerInfo, err := createContainer(spec)
+	if err != nil {
+		log.Errorf("Create container error: %v", err)
+		return
+	}
+
+	// 后台运行的容器交给shim进程启动并等待退出，run进程在容器启动后即可退出
+	if !spec.Tty {
+		if err := startShim(containerInfo.Name); err != nil {
+			log.Errorf("Start container %s error: %v", containerInfo.Name, err)
+		}
+		return
+	}
+	runForeground(containerInfo)
+}
+
+// 生成容器ID，建好容器目录并记录容器信息，此时容器处于created状态
+func createContainer(spec *container.Spec) (*container.ContainerInfo, error) {
 	// 生成容器ID
 	containerID := randStringBytes(10)
-	if containerName == "" {
-		containerName = containerID
+	if spec.Name == "" {
+		spec.Name = containerID
+	}
+	containerName := spec.Name
+	if exist, _ := container.PathExists(fmt.Sprintf(vars.DefaultInfoLocation, containerName)); exist {
+		return nil, fmt.Errorf("container %s already exists", containerName)
 	}
 
-	imageName := commandArray[0]
+	// 镜像不存在时不创建容器，旧版本的镜像tar包在这里导入镜像存储
+	if spec.Rootfs == "" {
+		id, err := image.Resolve(spec.Image)
+		if err != nil {
+			return nil, err
+		}
+		img, err := image.Get(id)
+		if err != nil {
+			return nil, err
+		}
+		applyImageConfig(spec, img.Config.Config)
+	}
+	if len(spec.Command) == 0 {
+		return nil, fmt.Errorf("no command specified")
+	}
 
-	// 提前建好目录
+	// 提前建好目录，直接使用rootfs的容器不需要overlay的目录
 	os.MkdirAll(path.Join(vars.ContainersRootPath, containerName), 0755)
-	dirs := []string{
-		fmt.Sprintf(vars.LowerDir, containerName),
-		fmt.Sprintf(vars.UpperDir, containerName),
-		fmt.Sprintf(vars.WorkDir, containerName),
-		fmt.Sprintf(vars.MntDir, containerName),
-	}
-	for _, dir := range dirs {
-		exist, err := container.PathExists(dir)
-		if !exist && err == nil {
-			if err = os.MkdirAll(dir, 0755); err != nil {
-				fmt.Printf("Mkdir %s error: %v\n", dir, err)
+	if spec.Rootfs == "" {
+		dirs := []string{
+			fmt.Sprintf(vars.LowerDir, containerName),
+			fmt.Sprintf(vars.UpperDir, containerName),
+			fmt.Sprintf(vars.WorkDir, containerName),
+			fmt.Sprintf(vars.MntDir, containerName),
+		}
+		for _, dir := range dirs {
+			exist, err := container.PathExists(dir)
+			if !exist && err == nil {
+				if err = os.MkdirAll(dir, 0755); err != nil {
+					fmt.Printf("Mkdir %s error: %v\n", dir, err)
+				}
 			}
 		}
 	}
 
-	cmd, writePipe := container.NewParentProcess(tty, volume, containerName, imageName, env)
-	if cmd == nil {
-		log.Errorf("New parent process error")
-		return
+	// 没有指定主机名时与docker一样使用容器ID
+	if spec.Hostname == "" && spec.Rootfs == "" && spec.HasNamespace("uts") {
+		spec.Hostname = containerID
 	}
 
-	// exec.Command.Run()会阻塞当前程序，直到命令执行完成；exec.Command.Start()允许你在命令执行的同时，继续执行其他操作，符合容器运行情况。
-	if err := cmd.Start(); err != nil {
-		log.Error(err)
+	// 每个容器使用独立的cgroup：mydocker/<容器ID>
+	cgroupPath := spec.CgroupPath
+	if cgroupPath == "" {
+		cgroupPath = path.Join(vars.CgroupParent, containerID)
 	}
 
-	// 记录容器信息
-	containerName, err := recordContainerInfo(cmd.Process.Pid, commandArray[1:], containerName, containerID, volume)
+	return recordContainerInfo(spec, containerID, cgroupPath)
+}
+
+// 用镜像config补全spec中没有指定的命令、工作目录和用户，环境变量与镜像的合并，同名时spec中的生效。
+// 与docker一样，镜像有Entrypoint时容器命令为Entrypoint+Cmd，指定的命令只替换Cmd
+func applyImageConfig(spec *container.Spec, config image.ContainerConfig) {
+	command := spec.Command
+	if len(command) == 0 {
+		command = config.Cmd
+	}
+	spec.Command = append(append([]string{}, config.Entrypoint...), command...)
+	spec.Env = container.MergeEnv(config.Env, spec.Env)
+	if spec.Workdir == "" {
+		spec.Workdir = config.WorkingDir
+	}
+	if spec.User == "" {
+		spec.User = config.User
+	}
+}
+
+// 前台运行容器，等待容器退出后清理容器并以容器的退出码退出
+func runForeground(containerInfo *container.ContainerInfo) {
+	cmd, err := startContainer(containerInfo, true)
 	if err != nil {
-		log.Errorf("Record container info error: %v", err)
+		log.Errorf("Start container %s error: %v", containerInfo.Name, err)
+		return
+	}
+
+	// 容器进程以非0退出码退出时Wait也会返回error，退出码从ProcessState中获取
+	err = cmd.Wait()
+	if err != nil && cmd.ProcessState == nil {
+		log.Errorf("parent.Wait() error: %v\n", err)
 	}
+	exitCode := -1
+	if cmd.ProcessState != nil {
+		exitCode = container.ExitCode(cmd.ProcessState)
+	}
+	log.Infof("container %s exited with code %d", containerInfo.Name, exitCode)
 
-	// 用mydocker-cgroup作为cgroup名称
-	cgroupManager := cgroups.NewCgroupManager("mydocker-cgroup")
-	cgroupManager.Resource = res
+	// 如果tty方式，在退出时清理容器信息
+	disconnectNetwork(containerInfo)
+	cgroups.NewCgroupManager(containerInfo.CgroupPath).Destory()
+	// 为什么不能用defer？？？？？？？？？？？？？？？？？
+	// 先卸载overlay，否则容器目录删除不干净
+	removeWorkSpace(containerInfo)
+	deleteContainerInfo(containerInfo.Name)
 
-	// 退出run时销毁cgroup
-	defer cgroupManager.Destory()
-	cgroupManager.Set()
-	cgroupManager.Apply(cmd.Process.Pid)
+	os.Exit(exitCode)
+}
 
-	if networkName != "" {
-		network.Init()
-		containerInfo := &container.ContainerInfo{
-			Id:          containerID,
-			Pid:         strconv.Itoa(cmd.Process.Pid),
-			Name:        containerName,
-			PortMapping: portMapping,
+// 卸载容器的overlay和数据卷，直接使用rootfs的容器(如oci bundle)不能删除rootfs
+func removeWorkSpace(containerInfo *container.ContainerInfo) {
+	if containerInfo.Rootfs != "" {
+		return
+	}
+	imageID := containerImageID(containerInfo.Name)
+	container.DeleteWorkSpace(containerInfo.Name, containerInfo.Volumes)
+	// 镜像已经被rmi -f删除时，清理只有该容器还在使用的镜像层
+	if imageID != "" {
+		if _, err := image.Get(imageID); err != nil {
+			removeDanglingLayers()
 		}
-		if err := network.Connect(networkName, containerInfo); err != nil {
-			log.Errorf("connect network error: %v", err)
-			return
+	}
+}
+
+// 根据容器信息启动容器进程：创建父进程、加入cgroup、连接网络，最后通过管道把用户命令发给容器init进程
+func startContainer(containerInfo *container.ContainerInfo, tty bool) (*exec.Cmd, error) {
+	cmd, writePipe, replyPipe := container.NewParentProcess(tty, containerInfo)
+	if cmd == nil {
+		return nil, fmt.Errorf("new parent process error")
+	}
+
+	// exec.Command.Run()会阻塞当前程序，直到命令执行完成；exec.Command.Start()允许你在命令执行的同时，继续执行其他操作，符合容器运行情况。
+	err := cmd.Start()
+	// 关闭本进程持有的管道另一端，init进程退出或exec后才能读到EOF
+	for _, f := range cmd.ExtraFiles {
+		f.Close()
+	}
+	if err != nil {
+		writePipe.Close()
+		replyPipe.Close()
+		containerInfo.SetDead()
+		if err := updateContainerInfo(containerInfo); err != nil {
+			log.Errorf("Update container %s info error %v", containerInfo.Name, err)
 		}
+		return nil, err
 	}
 
-	// 将命令行参数传入到writePipe中
-	sendInitCommand(commandArray[1:], writePipe)
+	// 重启的容器复用原来的cgroup，记录此时的OOM kill次数，退出时比较才知道本次运行是否被OOM killer杀死
+	containerInfo.OOMKillBase = oomKillCount(containerInfo)
 
-	if tty {
-		err := cmd.Wait()
-		if err != nil {
-			log.Errorf("parent.Wait() error: %v\n", err)
+	// oci create的容器在oci start之前一直处于created状态
+	if containerInfo.ExecFifo != "" {
+		containerInfo.SetCreated(cmd.Process.Pid)
+	} else {
+		containerInfo.SetRunning(cmd.Process.Pid)
+	}
+	if err := updateContainerInfo(containerInfo); err != nil {
+		log.Errorf("Update container %s info error %v", containerInfo.Name, err)
+	}
+
+	if containerInfo.Resource == nil {
+		containerInfo.Resource = &subsystems.ResourceConfig{}
+	}
+	// cgroup随容器一起保留，由stop/rm(或-ti模式退出时)负责销毁
+	cgroupManager := cgroups.NewCgroupManager(containerInfo.CgroupPath)
+	cgroupManager.Resource = containerInfo.Resource
+	if err = cgroupManager.Set(); err == nil {
+		err = cgroupManager.Apply(cmd.Process.Pid)
+	}
+	if err != nil {
+		// 资源限制没有生效时不运行用户命令
+		abortStart(cmd, writePipe, replyPipe, containerInfo)
+		return nil, err
+	}
+
+	if containerInfo.Resource.OomScoreAdj != "" {
+		if err := setOomScoreAdj(cmd.Process.Pid, containerInfo.Resource.OomScoreAdj); err != nil {
+			log.Errorf("Set oom score adj error: %v", err)
+		}
+	}
+
+	if containerInfo.Network != "" {
+		network.Init()
+		if err := network.Connect(containerInfo.Network, containerInfo); err != nil {
+			// 释放已经分配的ip和创建了一半的网络端点
+			abortStart(cmd, writePipe, replyPipe, containerInfo)
+			return nil, fmt.Errorf("connect network error: %v", err)
+		}
+		// 记录分配到的ip，容器退出时释放
+		if err := updateContainerInfo(containerInfo); err != nil {
+			log.Errorf("Update container %s info error %v", containerInfo.Name, err)
 		}
+	}
 
-		// 如果tty方式，在退出时清理容器信息
-		deleteContainerInfo(containerName)
-		// 为什么不能用defer？？？？？？？？？？？？？？？？？
-		container.DeleteWorkSpace(containerName, volume)
+	// 将用户命令等配置传入到writePipe中，并等待init进程exec用户命令
+	initConfig := &container.InitConfig{
+		Args:           containerInfo.Args,
+		Env:            container.WithDefaultPath(containerInfo.Env),
+		Cwd:            containerInfo.Workdir,
+		User:           containerInfo.User,
+		Hostname:       containerInfo.Hostname,
+		Mounts:         containerInfo.Mounts,
+		Init:           containerInfo.Init,
+		Rootfs:         containerInfo.RootfsPath(),
+		ReadonlyRootfs: containerInfo.ReadonlyRootfs,
+		ExecFifo:       containerInfo.ExecFifo,
+	}
+	if len(initConfig.Mounts) == 0 {
+		initConfig.Mounts = container.DefaultMounts()
+	}
+	log.Infof("command is %q", initConfig.Args)
+	err = container.SendInitConfig(writePipe, initConfig)
+	if err == nil {
+		err = container.WaitInitReply(replyPipe)
+	} else {
+		replyPipe.Close()
+	}
+	if err != nil {
+		// init进程写入错误信息后会自行退出，回收后记录退出状态
+		containerInfo.SetExited(waitContainer(cmd))
+		disconnectNetwork(containerInfo)
+		if err := updateContainerInfo(containerInfo); err != nil {
+			log.Errorf("Update container %s info error %v", containerInfo.Name, err)
+		}
+		return nil, err
+	}
+	return cmd, nil
+}
 
-		os.Exit(0)
+// 容器启动中途失败：关闭管道，杀死并回收还在等待配置的init进程，断开网络后将容器记为dead
+func abortStart(cmd *exec.Cmd, writePipe, replyPipe *os.File, containerInfo *container.ContainerInfo) {
+	writePipe.Close()
+	replyPipe.Close()
+	cmd.Process.Kill()
+	waitContainer(cmd)
+	disconnectNetwork(containerInfo)
+	containerInfo.SetDead()
+	if err := updateContainerInfo(containerInfo); err != nil {
+		log.Errorf("Update container %s info error %v", containerInfo.Name, err)
 	}
 }
 
-func sendInitCommand(commandArray []string, writePipe *os.File) {
-	command := strings.Join(commandArray, " ")
-	log.Infof("command is %s", command)
+// 断开容器的网络连接，释放容器占用的ip和端口映射
+func disconnectNetwork(containerInfo *container.ContainerInfo) {
+	if containerInfo.Network == "" || containerInfo.IPAddress == "" {
+		return
+	}
+	network.Init()
+	if err := network.Disconnect(containerInfo.Network, containerInfo); err != nil {
+		log.Errorf("disconnect network error: %v", err)
+	}
+}
 
-	writePipe.WriteString(command)
-	writePipe.Close()
+// 设置进程被OOM killer选中的倾向，范围-1000~1000，容器内的子进程会继承该值
+func setOomScoreAdj(pid int, oomScoreAdj string) error {
+	adj, err := strconv.Atoi(oomScoreAdj)
+	if err != nil || adj < -1000 || adj > 1000 {
+		return fmt.Errorf("invalid oom score adj %s", oomScoreAdj)
+	}
+	return os.WriteFile(fmt.Sprintf("/proc/%d/oom_score_adj", pid), []byte(strconv.Itoa(adj)), 0644)
 }
 
-// 记录容器相关信息
-func recordContainerInfo(containerPID int, commandArray []string, containerName, containerID, volume string) (string, error) {
+// 记录容器相关信息，写入config.json，并将spec保存到spec.json
+func recordContainerInfo(spec *container.Spec, containerID, cgroupPath string) (*container.ContainerInfo, error) {
 	// 以当前时间作为容器的创建时间
-	createTime := time.Now().Format("2006-01-02 15:04:05")
-	// 容器的命令
-	command := strings.Join(commandArray, " ")
+	createTime := time.Now().Format(vars.TimeFormat)
 
 	// 生成容器信息
-	containerInfo := container.ContainerInfo{
+	containerInfo := &container.ContainerInfo{
 		Id:          containerID,
-		Pid:         strconv.Itoa(containerPID),
-		Name:        containerName,
-		Command:     command,
+		Name:        spec.Name,
+		Image:       spec.Image,
+		Command:     strings.Join(spec.Command, " "),
+		Args:        spec.Command,
+		Env:         spec.Env,
 		CreatedTime: createTime,
-		Status:      vars.RUNNING,
-		Volume:      volume,
-	}
+		Status:      vars.CREATED,
+		Volumes:     spec.Volumes,
+		CgroupPath:  cgroupPath,
+		Network:     spec.Network,
+		PortMapping: spec.Ports,
+		StopSignal:  spec.StopSignal,
+		Init:        spec.Init,
+		Hostname:    spec.Hostname,
+		User:        spec.User,
+		Workdir:     spec.Workdir,
+		Resource:    spec.Resources,
 
-	// 将容器信息转换为json
-	jsonBytes, err := json.Marshal(containerInfo)
-	if err != nil {
-		log.Errorf("Record containerInfo error: %v", err)
+		Rootfs:         spec.Rootfs,
+		ReadonlyRootfs: spec.ReadonlyRootfs,
+		Mounts:         spec.Mounts,
+		Namespaces:     spec.Namespaces,
+
+		RestartPolicy: spec.RestartPolicy,
 	}
-	jsonstr := string(jsonBytes)
 
 	// 创建当前容器存储容器信息的目录
-	dir := fmt.Sprintf(vars.DefaultInfoLocation, containerName)
+	dir := fmt.Sprintf(vars.DefaultInfoLocation, spec.Name)
 	if err := os.MkdirAll(dir, 0622); err != nil {
-		log.Errorf("mkdir %s error: %v", dir, err)
-		return "", nil
+		return nil, fmt.Errorf("mkdir %s error: %v", dir, err)
 	}
 
-	// 创建当前容器存储容器信息的文件
-	fileName := path.Join(dir, vars.ConfigName)
-	file, err := os.Create(fileName)
-	defer file.Close()
-	if err != nil {
-		log.Errorf("Create file %s error %v", fileName, err)
-		return "", nil
+	if err := container.SaveSpec(path.Join(dir, vars.SpecName), spec); err != nil {
+		return nil, err
 	}
-
-	// 将容器信息写入到文件中
-	if _, err := file.WriteString(jsonstr); err != nil {
-		log.Errorf("File write string error %v", err)
-		return "", err
+	if err := updateContainerInfo(containerInfo); err != nil {
+		return nil, err
 	}
-
-	return containerName, nil
+	return containerInfo, nil
 }
 
 // 清理指定容器的相关信息
@@ -0,0 +1,204 @@
+package cmd
+
+import (
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"io"
+	"mydocker/container"
+	"mydocker/vars"
+	"os"
+	"os/exec"
+	"path"
+	"strconv"
+	"syscall"
+	"time"
+)
+
+/*
+	后台运行的容器由一个常驻的shim进程负责：
+	1. run进程重新执行/proc/self/exe shim <容器名>，shim脱离run进程的会话，run进程退出后shim继续运行
+	2. shim启动容器进程，成为容器init进程的父进程，并持有容器的日志文件
+	3. 容器启动结果通过fd 3上的状态管道告诉run进程：没有内容即启动成功，否则为错误信息
+	4. 容器进程退出后，shim回收容器进程并将退出码写入config.json，再根据重启策略决定是否重新启动容器
+*/
+
+const (
+	// 自动重启的退避时间从100ms开始，每次翻倍，最长1分钟
+	restartBackoffInitial = 100 * time.Millisecond
+	restartBackoffMax     = time.Minute
+	// 容器运行超过10s后退出，退避时间重置
+	restartBackoffReset = 10 * time.Second
+)
+
+// 启动shim进程并等待其报告容器的启动结果
+func startShim(containerName string) error {
+	statusRead, statusWrite, err := os.Pipe()
+	if err != nil {
+		return fmt.Errorf("new status pipe error %v", err)
+	}
+	defer statusRead.Close()
+
+	shimLogPath := path.Join(fmt.Sprintf(vars.DefaultInfoLocation, containerName), vars.ShimLogFile)
+	shimLog, err := os.OpenFile(shimLogPath, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		statusWrite.Close()
+		return fmt.Errorf("open shim log %s error %v", shimLogPath, err)
+	}
+	defer shimLog.Close()
+
+	cmd := exec.Command("/proc/self/exe", "shim", containerName)
+	cmd.Stdout = shimLog
+	cmd.Stderr = shimLog
+	cmd.ExtraFiles = []*os.File{statusWrite}
+	// 新建会话，不随run进程所在终端的退出而收到SIGHUP
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setsid: true}
+	if err := cmd.Start(); err != nil {
+		statusWrite.Close()
+		return fmt.Errorf("start shim error %v", err)
+	}
+	// 关闭本进程持有的写端，shim关闭写端后读取才会返回EOF
+	statusWrite.Close()
+
+	msg, err := io.ReadAll(statusRead)
+	if err != nil {
+		return fmt.Errorf("read shim status error %v", err)
+	}
+	// shim不由run进程回收，交给系统的init进程
+	cmd.Process.Release()
+	if len(msg) > 0 {
+		return fmt.Errorf("%s", msg)
+	}
+	return nil
+}
+
+// RunShim 在shim进程中启动容器，并一直等到容器进程退出
+func RunShim(containerName string) error {
+	statusPipe := os.NewFile(uintptr(3), "status")
+	// 避免状态管道被容器进程继承，否则run进程要等到容器退出才能读到EOF
+	syscall.CloseOnExec(3)
+
+	containerInfo, err := getContainerInfo(containerName)
+	if err != nil {
+		fmt.Fprintf(statusPipe, "get container %s info error %v", containerName, err)
+		statusPipe.Close()
+		return err
+	}
+	containerInfo.ShimPid = strconv.Itoa(os.Getpid())
+
+	cmd, err := startContainer(containerInfo, false)
+	if err != nil {
+		fmt.Fprintf(statusPipe, "%v", err)
+		statusPipe.Close()
+		return err
+	}
+	statusPipe.Close()
+
+	backoff := restartBackoffInitial
+	for {
+		exitCode := waitContainer(cmd)
+		log.Infof("container %s exited with code %d", containerName, exitCode)
+		containerInfo = recordContainerExit(containerName, exitCode)
+		if containerInfo == nil || containerInfo.Status != vars.RESTARTING {
+			return nil
+		}
+
+		// 容器稳定运行一段时间后才退出的，重新从最小的退避时间开始计算
+		if startedAt, err := time.ParseInLocation(vars.TimeFormat, containerInfo.StartedAt, time.Local); err == nil && time.Since(startedAt) > restartBackoffReset {
+			backoff = restartBackoffInitial
+		}
+		log.Infof("restart container %s in %v", containerName, backoff)
+		if containerInfo = waitRestart(containerName, backoff); containerInfo == nil {
+			return nil
+		}
+		if backoff *= 2; backoff > restartBackoffMax {
+			backoff = restartBackoffMax
+		}
+
+		containerInfo.RestartCount++
+		if cmd, err = startContainer(containerInfo, false); err != nil {
+			log.Errorf("Restart container %s error %v", containerName, err)
+			return err
+		}
+	}
+}
+
+// 等待重启的退避时间。期间容器被stop时记为exited后放弃重启；被rm、或已由其他shim接管(ShimPid不是本进程)时直接放弃
+func waitRestart(containerName string, backoff time.Duration) *container.ContainerInfo {
+	shimPid := strconv.Itoa(os.Getpid())
+	deadline := time.Now().Add(backoff)
+	for {
+		containerInfo, err := getContainerInfo(containerName)
+		if err != nil {
+			// config.json不是原子写入的，读到写了一半的内容时下次再读
+			if exist, _ := container.PathExists(fmt.Sprintf(vars.DefaultInfoLocation, containerName)); !exist {
+				return nil
+			}
+		} else if containerInfo.ShimPid != shimPid {
+			return nil
+		} else if containerInfo.HasBeenManuallyStopped {
+			// stop在等待本shim退出，由shim将容器记为exited
+			containerInfo.Status = vars.EXIT
+			containerInfo.ShimPid = ""
+			if err := updateContainerInfo(containerInfo); err != nil {
+				log.Errorf("Update container %s info error %v", containerName, err)
+			}
+			return nil
+		} else if !time.Now().Before(deadline) {
+			return containerInfo
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+}
+
+// 回收容器进程并返回退出码，同时关闭shim持有的日志文件
+func waitContainer(cmd *exec.Cmd) int {
+	err := cmd.Wait()
+	if stdLogFile, ok := cmd.Stdout.(*os.File); ok && stdLogFile != os.Stdout {
+		stdLogFile.Close()
+	}
+	if cmd.ProcessState == nil {
+		log.Errorf("Wait container process error %v", err)
+		return -1
+	}
+	return container.ExitCode(cmd.ProcessState)
+}
+
+// 容器进程退出后，重新读取容器信息(期间可能被stop/update修改)，记录退出码及是否被OOM killer杀死，并释放容器的ip，
+// 需要重启时容器记为restarting
+func recordContainerExit(containerName string, exitCode int) *container.ContainerInfo {
+	containerInfo, err := getContainerInfo(containerName)
+	if err != nil {
+		log.Errorf("Get container %s info error %v", containerName, err)
+		return nil
+	}
+	// 容器已经被新的shim接管(如stop后马上start)，不能覆盖它的状态
+	if containerInfo.ShimPid != "" && containerInfo.ShimPid != strconv.Itoa(os.Getpid()) {
+		return nil
+	}
+
+	// 按重启策略需要重启时保留ShimPid，stop/start据此知道容器仍由本shim负责
+	if shouldRestart(containerInfo, exitCode) {
+		containerInfo.SetRestarting(exitCode)
+	} else {
+		containerInfo.SetExited(exitCode)
+		containerInfo.ShimPid = ""
+	}
+	// 只有容器进程被SIGKILL杀死、且本次运行期间发生过OOM kill才算被OOM killer杀死，
+	// 只杀死了容器中的子进程时容器进程仍然正常退出
+	containerInfo.OOMKilled = exitCode == 128+int(syscall.SIGKILL) && oomKillCount(containerInfo) > containerInfo.OOMKillBase
+	disconnectNetwork(containerInfo)
+	if err := updateContainerInfo(containerInfo); err != nil {
+		log.Errorf("Update container %s info error %v", containerName, err)
+	}
+	return containerInfo
+}
+
+// 根据重启策略判断容器退出后是否需要重启
+func shouldRestart(containerInfo *container.ContainerInfo, exitCode int) bool {
+	policy, err := container.ParseRestartPolicy(containerInfo.RestartPolicy)
+	if err != nil {
+		log.Errorf("Parse restart policy error %v", err)
+		return false
+	}
+	return policy.ShouldRestart(exitCode, containerInfo.HasBeenManuallyStopped, containerInfo.RestartCount)
+}
@@ -0,0 +1,55 @@
+package cmd
+
+import (
+	log "github.com/sirupsen/logrus"
+	"mydocker/container"
+	"mydocker/vars"
+	"time"
+)
+
+// StartContainer 重新启动一个已经停止的容器：复用原来的overlay读写层，重新挂载数据卷、连接网络并运行原来的命令
+func StartContainer(containerName string) {
+	containerInfo, err := getContainerInfo(containerName)
+	if err != nil {
+		log.Errorf("Get container %s info error %v", containerName, err)
+		return
+	}
+
+	syncContainerStatus(containerInfo)
+	// 等待重启的容器仍由原来的shim负责，再启动一个shim会在同一个overlay和cgroup上运行两份容器
+	if containerInfo.IsRunning() || containerInfo.Status == vars.RESTARTING {
+		log.Errorf("Container %s is already %s", containerName, containerInfo.Status)
+		return
+	}
+	// 上一个shim还没有退出时，它会在退出前覆盖容器的状态
+	if containerInfo.ShimPid != "" && container.IsProcessAlive(containerInfo.ShimPid) {
+		log.Errorf("Container %s is still stopping", containerName)
+		return
+	}
+
+	containerInfo.HasBeenManuallyStopped = false
+	containerInfo.ShimPid = ""
+	if err := updateContainerInfo(containerInfo); err != nil {
+		log.Errorf("Update container %s info error %v", containerName, err)
+		return
+	}
+
+	if err := startShim(containerName); err != nil {
+		log.Errorf("Start container %s error: %v", containerName, err)
+	}
+}
+
+// RestartContainer 停止容器(等待容器进程和shim退出)后再重新启动
+func RestartContainer(containerName string, timeout time.Duration) {
+	containerInfo, err := getContainerInfo(containerName)
+	if err != nil {
+		log.Errorf("Get container %s info error %v", containerName, err)
+		return
+	}
+
+	syncContainerStatus(containerInfo)
+	if containerInfo.IsRunning() || containerInfo.Status == vars.RESTARTING {
+		StopContainer(containerName, timeout)
+	}
+	StartContainer(containerName)
+}
@@ -0,0 +1,177 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"mydocker/cgroups"
+	"mydocker/network"
+	"mydocker/utils"
+	"mydocker/vars"
+	"os"
+	"text/tabwriter"
+	"time"
+)
+
+// 单个容器某一时刻的资源使用情况
+type containerStats struct {
+	Id               string  `json:"id"`
+	Name             string  `json:"name"`
+	CpuPercent       float64 `json:"cpuPercent"`
+	CpuThrottled     uint64  `json:"cpuNrThrottled"`
+	CpuThrottledTime uint64  `json:"cpuThrottledTime"`
+	MemoryUsage      uint64  `json:"memoryUsage"`
+	MemoryLimit      uint64  `json:"memoryLimit"`
+	MemoryPercent    float64 `json:"memoryPercent"`
+	PidsCurrent      uint64  `json:"pids"`
+	NetRxBytes       uint64  `json:"netRxBytes"`
+	NetTxBytes       uint64  `json:"netTxBytes"`
+
+	// 计算cpu使用率用的上一次采样
+	cpuUsage uint64
+	readTime time.Time
+}
+
+// Stats 周期性读取容器cgroup统计并刷新输出，noStream时只输出一次
+func Stats(containerNames []string, noStream bool, format string) {
+	if format != "" && format != "table" && format != "json" {
+		log.Errorf("Unsupported stats format %s", format)
+		return
+	}
+
+	// 未指定容器时统计所有运行中的容器
+	if len(containerNames) == 0 {
+		containers, err := os.ReadDir(vars.ContainersRootPath)
+		if err != nil {
+			log.Errorf("Read %s error: %v", vars.ContainersRootPath, err)
+			return
+		}
+		for _, c := range containers {
+			containerInfo, err := getContainerInfo(c.Name())
+			if err != nil || containerInfo.Status != vars.RUNNING {
+				continue
+			}
+			containerNames = append(containerNames, containerInfo.Name)
+		}
+	}
+
+	// cpu使用率需要两次采样的差值，先采一次作为基准
+	previous := map[string]*containerStats{}
+	for _, name := range containerNames {
+		if s, err := readContainerStats(name); err == nil {
+			previous[name] = s
+		}
+	}
+
+	for {
+		time.Sleep(time.Second)
+
+		var current []*containerStats
+		for _, name := range containerNames {
+			s, err := readContainerStats(name)
+			if err != nil {
+				log.Errorf("Read container %s stats error %v", name, err)
+				continue
+			}
+			if prev, ok := previous[name]; ok && s.cpuUsage >= prev.cpuUsage {
+				elapsed := s.readTime.Sub(prev.readTime).Nanoseconds()
+				if elapsed > 0 {
+					s.CpuPercent = float64(s.cpuUsage-prev.cpuUsage) / float64(elapsed) * 100
+				}
+			}
+			previous[name] = s
+			current = append(current, s)
+		}
+
+		if format == "json" {
+			printStatsJson(current)
+		} else {
+			if !noStream {
+				// 清屏并将光标移到左上角，实现表格的刷新
+				fmt.Fprint(os.Stdout, "\033[2J\033[H")
+			}
+			printStatsTable(current)
+		}
+
+		if noStream {
+			return
+		}
+	}
+}
+
+func readContainerStats(containerName string) (*containerStats, error) {
+	containerInfo, err := getContainerInfo(containerName)
+	if err != nil {
+		return nil, err
+	}
+	if containerInfo.CgroupPath == "" {
+		return nil, fmt.Errorf("container %s has no cgroup", containerName)
+	}
+
+	cgroupStats, err := cgroups.NewCgroupManager(containerInfo.CgroupPath).GetStats()
+	if err != nil {
+		return nil, err
+	}
+
+	s := &containerStats{
+		Id:               containerInfo.Id,
+		Name:             containerInfo.Name,
+		MemoryUsage:      cgroupStats.MemoryUsage,
+		MemoryLimit:      cgroupStats.MemoryLimit,
+		PidsCurrent:      cgroupStats.PidsCurrent,
+		CpuThrottled:     cgroupStats.CpuNrThrottled,
+		CpuThrottledTime: cgroupStats.CpuThrottledTime,
+		cpuUsage:         cgroupStats.CpuUsage,
+		readTime:         time.Now(),
+	}
+	if s.MemoryLimit > 0 {
+		s.MemoryPercent = float64(s.MemoryUsage) / float64(s.MemoryLimit) * 100
+	}
+
+	if containerInfo.Network != "" {
+		s.NetRxBytes, s.NetTxBytes, err = network.GetEndpointStats(containerInfo.Network, containerInfo)
+		if err != nil {
+			log.Warnf("Get container %s network stats error %v", containerName, err)
+		}
+	}
+	return s, nil
+}
+
+func printStatsTable(statsList []*containerStats) {
+	w := tabwriter.NewWriter(os.Stdout, 12, 1, 3, ' ', 0)
+	fmt.Fprintf(w, "ID\tNAME\tCPU %%\tTHROTTLED\tMEM USAGE / LIMIT\tMEM %%\tNET I/O\tPIDS\n")
+	for _, s := range statsList {
+		memLimit := "unlimited"
+		if s.MemoryLimit > 0 {
+			memLimit = utils.BytesSize(float64(s.MemoryLimit))
+		}
+		fmt.Fprintf(w, "%s\t%s\t%.2f%%\t%d (%s)\t%s / %s\t%.2f%%\t%s / %s\t%d\n",
+			s.Id,
+			s.Name,
+			s.CpuPercent,
+			s.CpuThrottled,
+			time.Duration(s.CpuThrottledTime),
+			utils.BytesSize(float64(s.MemoryUsage)),
+			memLimit,
+			s.MemoryPercent,
+			utils.BytesSize(float64(s.NetRxBytes)),
+			utils.BytesSize(float64(s.NetTxBytes)),
+			s.PidsCurrent,
+		)
+	}
+	if err := w.Flush(); err != nil {
+		log.Errorf("Flush error: %v", err)
+	}
+}
+
+// json格式每个容器输出一行
+func printStatsJson(statsList []*containerStats) {
+	for _, s := range statsList {
+		jsonBytes, err := json.Marshal(s)
+		if err != nil {
+			log.Errorf("Json marshal stats error %v", err)
+			continue
+		}
+		fmt.Fprintln(os.Stdout, string(jsonBytes))
+	}
+}
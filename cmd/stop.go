@@ -1,29 +1,59 @@
 package cmd
 
 import (
-	"encoding/json"
 	"fmt"
 	log "github.com/sirupsen/logrus"
+	"mydocker/cgroups"
+	"mydocker/cgroups/subsystems"
+	"mydocker/container"
+	"mydocker/utils"
 	"mydocker/vars"
-	"os"
-	"path"
 	"strconv"
 	"syscall"
+	"time"
 )
 
-func StopContainer(containerName string) {
-	// 获取容器进程
-	pid, err := getContainerPidByName(containerName)
+// 发送SIGKILL后等待进程退出、以及等待shim记录退出状态的最长时间
+const killTimeout = 10 * time.Second
+
+// StopContainer 向容器init进程发送stop信号，timeout内没有退出则发送SIGKILL，进程真正退出后才更新容器状态
+func StopContainer(containerName string, timeout time.Duration) {
+	containerInfo, err := getContainerInfo(containerName)
 	if err != nil {
-		log.Errorf("Get container pid by name %s error %v", containerName, err)
+		log.Errorf("Get containerInfo from %s error %v", containerName, err)
+		return
+	}
+	syncContainerStatus(containerInfo)
+	if containerInfo.Status == vars.RESTARTING {
+		if containerInfo, err = stopRestarting(containerInfo); err != nil {
+			log.Errorf("Stop container %s error %v", containerName, err)
+			return
+		}
+		// shim刚好已经重新启动了容器，按运行中的容器停止
+		if containerInfo.IsRunning() {
+			StopContainer(containerName, timeout)
+		}
+		return
+	}
+	if !containerInfo.IsRunning() {
+		log.Errorf("Container %s is not running", containerName)
+		return
 	}
 
-	pidInt, err := strconv.Atoi(pid)
+	pidInt, err := strconv.Atoi(containerInfo.Pid)
 	if err != nil {
 		log.Errorf("Conver pid from string to int error %v", err)
 		return
 	}
 
+	stopSignal := syscall.SIGTERM
+	if containerInfo.StopSignal != "" {
+		if stopSignal, err = utils.ParseSignal(containerInfo.StopSignal); err != nil {
+			log.Warnf("Container %s stop signal error %v, use SIGTERM", containerName, err)
+			stopSignal = syscall.SIGTERM
+		}
+	}
+
 	// 杀死容器进程(附kill signal 列表）
 	/*
 		SIGHUP (1) - 挂起信号
@@ -49,27 +79,128 @@ func StopContainer(containerName string) {
 		SIGTTIN (21) - 后台进程尝试读取控制终端信号
 		SIGTTOU (22) - 后台进程尝试写控制终端信号
 	*/
-	if err := syscall.Kill(pidInt, syscall.SIGTERM); err != nil {
+	// 被冻结的进程在解冻前无法处理信号，先解冻
+	if containerInfo.Status == vars.PAUSED {
+		if err := cgroups.NewCgroupManager(containerInfo.CgroupPath).Freeze(subsystems.Thawed); err != nil {
+			log.Errorf("Unpause container %s error %v", containerName, err)
+			return
+		}
+		containerInfo.Status = vars.RUNNING
+	}
+
+	// 先标记为手动停止，shim看到后不会按重启策略重启容器
+	containerInfo.HasBeenManuallyStopped = true
+	if err := updateContainerInfo(containerInfo); err != nil {
+		log.Errorf("Update container %s info error %v", containerName, err)
+		return
+	}
+
+	// 容器init进程是pid namespace中的1号进程，没有注册处理函数的信号会被忽略，超时后只能用SIGKILL
+	if err := syscall.Kill(pidInt, stopSignal); err != nil {
 		log.Errorf("Stop container %s error %v", containerName, err)
 	}
+	if !waitProcessExit(containerInfo.Pid, timeout) {
+		log.Infof("Container %s did not stop in %v, kill it", containerName, timeout)
+		stopSignal = syscall.SIGKILL
+		if err := syscall.Kill(pidInt, syscall.SIGKILL); err != nil {
+			log.Errorf("Kill container %s error %v", containerName, err)
+		}
+		if !waitProcessExit(containerInfo.Pid, killTimeout) {
+			log.Errorf("Container %s is still running after SIGKILL", containerName)
+			return
+		}
+	}
 
-	// 修改容器运行状态信息
-	containerInfo, err := getContainerInfo(containerName)
+	// 后台容器的退出码由shim记录，等shim退出后再读取最新的容器信息
+	if err := waitContainerStopped(containerName, killTimeout); err != nil {
+		log.Warnf("Wait container %s stopped error %v", containerName, err)
+	}
+	containerInfo, err = getContainerInfo(containerName)
 	if err != nil {
 		log.Errorf("Get containerInfo from %s error %v", containerName, err)
 		return
 	}
-	containerInfo.Status = vars.STOP
-	containerInfo.Pid = ""
-	newContentBytes, err := json.Marshal(containerInfo)
-	if err != nil {
-		log.Errorf("Json marshal %s error %v", containerName, err)
-		return
+	// 没有shim记录退出码时，按shell惯例记为128+信号值
+	if containerInfo.IsRunning() {
+		containerInfo.SetExited(128 + int(stopSignal))
+	}
+
+	// 进程已经退出，cgroup中没有进程了，可以销毁
+	if containerInfo.CgroupPath != "" {
+		cgroups.NewCgroupManager(containerInfo.CgroupPath).Destory()
+	}
+	if err := updateContainerInfo(containerInfo); err != nil {
+		log.Errorf("Update container %s info error %v", containerName, err)
+	}
+}
+
+// 停止等待重启的容器：标记为手动停止，等shim看到后放弃重启、将容器记为exited并退出，再销毁容器的cgroup
+func stopRestarting(containerInfo *container.ContainerInfo) (*container.ContainerInfo, error) {
+	containerName := containerInfo.Name
+	containerInfo.HasBeenManuallyStopped = true
+	if err := updateContainerInfo(containerInfo); err != nil {
+		return nil, fmt.Errorf("update container %s info error %v", containerName, err)
+	}
+
+	deadline := time.Now().Add(killTimeout)
+	for containerInfo.Status == vars.RESTARTING {
+		// shim已经不在了，不会再有人重启容器
+		if containerInfo.ShimPid == "" || !container.IsProcessAlive(containerInfo.ShimPid) {
+			containerInfo.Status = vars.EXIT
+			containerInfo.ShimPid = ""
+			break
+		}
+		if time.Now().After(deadline) {
+			return nil, fmt.Errorf("shim %s did not exit in %v", containerInfo.ShimPid, killTimeout)
+		}
+		time.Sleep(100 * time.Millisecond)
+		newInfo, err := getContainerInfo(containerName)
+		if err != nil {
+			return nil, err
+		}
+		containerInfo = newInfo
+	}
+	if containerInfo.IsRunning() {
+		return containerInfo, nil
+	}
+
+	if containerInfo.CgroupPath != "" {
+		cgroups.NewCgroupManager(containerInfo.CgroupPath).Destory()
+	}
+	if err := updateContainerInfo(containerInfo); err != nil {
+		return nil, fmt.Errorf("update container %s info error %v", containerName, err)
 	}
+	return containerInfo, nil
+}
+
+// 轮询等待进程退出，进程变为僵尸也视为已经退出
+func waitProcessExit(pid string, timeout time.Duration) bool {
+	deadline := time.Now().Add(timeout)
+	for container.IsProcessAlive(pid) {
+		if time.Now().After(deadline) {
+			return false
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	return true
+}
 
-	dirUrl := fmt.Sprintf(vars.DefaultInfoLocation, containerName)
-	configFilePath := path.Join(dirUrl, vars.ConfigName)
-	if err := os.WriteFile(configFilePath, newContentBytes, 0622); err != nil {
-		log.Errorf("Write file %s error %v", configFilePath, err)
+// 轮询等待容器进程和负责它的shim都退出
+func waitContainerStopped(containerName string, timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	for {
+		containerInfo, err := getContainerInfo(containerName)
+		if err != nil {
+			return err
+		}
+		processAlive := containerInfo.Pid != "" && container.IsProcessAlive(containerInfo.Pid)
+		shimAlive := containerInfo.ShimPid != "" && container.IsProcessAlive(containerInfo.ShimPid)
+		if !processAlive && !shimAlive {
+			return nil
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("container %s did not stop in %v", containerName, timeout)
+		}
+		time.Sleep(100 * time.Millisecond)
 	}
 }
@@ -0,0 +1,53 @@
+package cmd
+
+import (
+	log "github.com/sirupsen/logrus"
+	"mydocker/cgroups"
+	"mydocker/cgroups/subsystems"
+	"strconv"
+)
+
+// 修改运行中容器的资源限制，并将新的限制保存到config.json
+func UpdateContainer(containerName string, res *subsystems.ResourceConfig) {
+	containerInfo, err := getContainerInfo(containerName)
+	if err != nil {
+		log.Errorf("Get container %s info error %v", containerName, err)
+		return
+	}
+
+	if !containerInfo.IsRunning() {
+		log.Errorf("Could not update container %s which is %s", containerName, containerInfo.Status)
+		return
+	}
+	if containerInfo.CgroupPath == "" {
+		log.Errorf("Container %s has no cgroup", containerName)
+		return
+	}
+
+	// 在当前限制的副本上合并新参数后整体写入cgroup：如只修改--cpu-period时也需要原来的--cpus才能算出quota
+	merged := &subsystems.ResourceConfig{}
+	if containerInfo.Resource != nil {
+		*merged = *containerInfo.Resource
+	}
+	merged.Merge(res)
+	cgroupManager := cgroups.NewCgroupManager(containerInfo.CgroupPath)
+	cgroupManager.Resource = merged
+	if err := cgroupManager.Set(); err != nil {
+		// 写入失败的限制没有生效，不保存到config.json
+		log.Errorf("Update container %s resources error %v", containerName, err)
+		return
+	}
+
+	if res.OomScoreAdj != "" {
+		pid, _ := strconv.Atoi(containerInfo.Pid)
+		if err := setOomScoreAdj(pid, res.OomScoreAdj); err != nil {
+			log.Errorf("Set oom score adj error: %v", err)
+			return
+		}
+	}
+
+	containerInfo.Resource = merged
+	if err := updateContainerInfo(containerInfo); err != nil {
+		log.Errorf("Update container %s info error %v", containerName, err)
+	}
+}
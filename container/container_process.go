@@ -3,6 +3,7 @@ package container
 import (
 	"fmt"
 	log "github.com/sirupsen/logrus"
+	"mydocker/cgroups/subsystems"
 	"mydocker/vars"
 	"os"
 	"os/exec"
@@ -14,28 +15,72 @@ type ContainerInfo struct {
 	Pid         string   `json:"pid"`         // 容器的init进程在主机上的pid
 	Id          string   `json:"id"`          // 容器id
 	Name        string   `json:"name"`        // 容器名称
+	Image       string   `json:"image"`       // 容器使用的镜像
 	Command     string   `json:"command"`     // 容器的init运行命令
+	Args        []string `json:"args"`        // 容器的init运行命令及参数
+	Env         []string `json:"env"`         // 容器的环境变量
 	CreatedTime string   `json:"createTime"`  // 容器创建时间
 	Status      string   `json:"status"`      // 容器的状态
-	Volume      string   `json:"volume"`      // 容器的数据卷
+	ShimPid     string   `json:"shimPid"`     // 后台运行时，负责等待容器进程退出的shim进程pid
+	Volumes     []string `json:"volumes"`     // 容器的数据卷
 	PortMapping []string `json:"portMapping"` // 端口映射
+	Network     string   `json:"network"`     // 容器连接的网络
+	CgroupPath  string   `json:"cgroupPath"`  // 容器的cgroup路径(相对于cgroup根目录)
+	StartedAt   string   `json:"startedAt"`   // 容器进程最近一次启动的时间
+	FinishedAt  string   `json:"finishedAt"`  // 容器进程最近一次退出的时间
+	ExitCode    int      `json:"exitCode"`    // 容器进程的退出码
+	OOMKilled   bool     `json:"oomKilled"`   // 容器进程是否被OOM killer杀死
+	OOMKillBase uint64   `json:"oomKillBase"` // 本次启动时cgroup中已有的OOM kill次数，重启时cgroup复用，计数是累计的
+	IPAddress   string   `json:"ip"`          // 容器在网络中分配到的ip
+	StopSignal  string   `json:"stopSignal"`  // stop时发给容器init进程的信号，默认SIGTERM
+	Init        bool     `json:"init"`        // 是否由mydocker init作为1号进程转发信号、回收僵尸进程
+	Hostname    string   `json:"hostname"`    // 容器的主机名
+	User        string   `json:"user"`        // 运行用户命令的用户
+	Workdir     string   `json:"workdir"`     // 用户命令的工作目录
+
+	Rootfs         string   `json:"rootfs,omitempty"`         // 直接使用的根文件系统，为空时使用镜像的overlay
+	ReadonlyRootfs bool     `json:"readonlyRootfs,omitempty"` // 根文件系统只读
+	Mounts         []Mount  `json:"mounts,omitempty"`         // 容器内的挂载，为空时挂载默认的/proc和/dev
+	Namespaces     []string `json:"namespaces,omitempty"`     // 使用的命名空间，为空时使用默认的命名空间
+	Bundle         string   `json:"bundle,omitempty"`         // oci bundle目录
+	ExecFifo       string   `json:"execFifo,omitempty"`       // oci create后init进程在该fifo上等待oci start
+
+	RestartPolicy          string `json:"restartPolicy"`   // 重启策略 no|on-failure[:N]|always|unless-stopped
+	RestartCount           int    `json:"restartCount"`    // 已经自动重启的次数
+	HasBeenManuallyStopped bool   `json:"manuallyStopped"` // 是否被stop手动停止，手动停止的容器不再自动重启
+
+	Resource *subsystems.ResourceConfig `json:"resource"` // 容器的资源限制
 }
 
 // NewParentProcess 创建容器的父进程
-func NewParentProcess(tty bool, volume, containerName, imageName string, env []string) (*exec.Cmd, *os.File) {
-	//
+func NewParentProcess(tty bool, containerInfo *ContainerInfo) (*exec.Cmd, *os.File, *os.File) {
+	containerName := containerInfo.Name
+	cloneflags, err := CloneFlags(containerInfo.Namespaces)
+	if err != nil {
+		log.Errorf("Get clone flags error %v", err)
+		return nil, nil, nil
+	}
+
+	// 配置管道，传递用户命令、环境变量等
 	readPipe, writePipe, err := NewPipe()
 	if err != nil {
 		log.Errorf("New pipe error %v", err)
-		return nil, nil
+		return nil, nil, nil
+	}
+	// 结果管道，返回init进程的启动结果
+	replyRead, replyWrite, err := NewPipe()
+	if err != nil {
+		log.Errorf("New pipe error %v", err)
+		return nil, nil, nil
 	}
 
 	//
 	cmd := exec.Command("/proc/self/exe", "init", containerName)
-	cmd.Env = env
+	// 用户的环境变量通过配置管道传给init进程，init进程本身不需要
+	cmd.Env = []string{}
 	cmd.SysProcAttr = &syscall.SysProcAttr{
 		// 利用clone fork出来一个新进程，并使用namespace隔离
-		Cloneflags: syscall.CLONE_NEWUTS | syscall.CLONE_NEWPID | syscall.CLONE_NEWNS | syscall.CLONE_NEWNET | syscall.CLONE_NEWIPC,
+		Cloneflags: cloneflags,
 	}
 
 	// 如果用户指定了-ti，则tty为true，需要将当前进程的输入输出导入到标准输入输出
@@ -47,27 +92,35 @@ func NewParentProcess(tty bool, volume, containerName, imageName string, env []s
 		dirUrl := fmt.Sprintf(vars.DefaultInfoLocation, containerName)
 		if err := os.MkdirAll(dirUrl, 0622); err != nil {
 			log.Errorf("NewParentProcess mkdir %s error %v", dirUrl, err)
-			return nil, nil
+			return nil, nil, nil
 		}
 		stdLogFilePath := path.Join(dirUrl, vars.ContainerLogFile)
-		stdLogFile, err := os.Create(stdLogFilePath)
+		// 以追加方式打开，容器重启后保留之前的日志
+		stdLogFile, err := os.OpenFile(stdLogFilePath, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
 		if err != nil {
 			log.Errorf("NewParentProcess create file %s error %v", stdLogFilePath, err)
 		}
-		// 将标准输出写入到日志文件
+		// 将标准输出和标准错误写入到日志文件
 		cmd.Stdout = stdLogFile
+		cmd.Stderr = stdLogFile
 	}
 
-	// 传入pipe读取端
-	cmd.ExtraFiles = []*os.File{readPipe}
+	// 传入配置管道读取端(fd 3)和结果管道写入端(fd 4)
+	cmd.ExtraFiles = []*os.File{readPipe, replyWrite}
 
-	NewWorkSpace(volume, imageName, containerName)
+	// 指定了rootfs时直接使用，不创建overlay
+	if containerInfo.Rootfs == "" {
+		if err := NewWorkSpace(containerInfo.Volumes, containerInfo.Image, containerName); err != nil {
+			log.Errorf("New workspace error %v", err)
+			return nil, nil, nil
+		}
+	}
 
 	// 指定cmd工作目录
-	cmd.Dir = fmt.Sprintf(vars.MntDir, containerName)
+	cmd.Dir = containerInfo.RootfsPath()
 
-	// 返回cmd及pipe写入端
-	return cmd, writePipe
+	// 返回cmd、配置管道写入端及结果管道读取端
+	return cmd, writePipe, replyRead
 }
 
 func NewPipe() (*os.File, *os.File, error) {
@@ -77,3 +130,11 @@ func NewPipe() (*os.File, *os.File, error) {
 	}
 	return read, write, nil
 }
+
+// RootfsPath 容器根文件系统在主机上的路径
+func (c *ContainerInfo) RootfsPath() string {
+	if c.Rootfs != "" {
+		return c.Rootfs
+	}
+	return fmt.Sprintf(vars.MntDir, c.Name)
+}
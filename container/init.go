@@ -6,9 +6,7 @@ import (
 	"github.com/moby/sys/mountinfo"
 	log "github.com/sirupsen/logrus"
 	"golang.org/x/sys/unix"
-	"io/ioutil"
 	"mydocker/utils"
-	"mydocker/vars"
 	"os"
 	"os/exec"
 	"path/filepath"
@@ -17,52 +15,193 @@ import (
 )
 
 func RunContainerInitProcess(containerName string) error {
-	commandArray := ReadUserCommand()
-	if commandArray == nil || len(commandArray) == 0 {
-		return fmt.Errorf("Run container get user command error, commandArray is nil")
-	}
+	reply := os.NewFile(uintptr(4), "reply")
+	// exec用户命令成功后结果管道自动关闭，父进程读到EOF
+	syscall.CloseOnExec(4)
 
-	setupMount(containerName)
+	if err := initContainer(containerName, reply); err != nil {
+		log.Errorf("init container error %v", err)
+		fmt.Fprintf(reply, "%v", err)
+		reply.Close()
+		return err
+	}
+	return nil
+}
 
-	// 在PATH环境变量内搜索commandArray[0]，并返回绝对路径或者时一个相对于当前目录的相对路径
-	path, err := exec.LookPath(commandArray[0])
+func initContainer(containerName string, reply *os.File) error {
+	config, err := ReadInitConfig()
 	if err != nil {
-		log.Errorf("Exec loop path error %v", err)
 		return err
 	}
+	log.Infof("init container %s, rootfs %s", containerName, config.Rootfs)
+
+	// oci create的容器：fifo在主机的路径上，切换根目录前先打开
+	execFifo := -1
+	if config.ExecFifo != "" {
+		if execFifo, err = unix.Open(config.ExecFifo, unix.O_PATH|unix.O_CLOEXEC, 0); err != nil {
+			return fmt.Errorf("open exec fifo %s error %v", config.ExecFifo, err)
+		}
+	}
+
+	if err := setupMount(config); err != nil {
+		return err
+	}
+	if config.Hostname != "" {
+		if err := syscall.Sethostname([]byte(config.Hostname)); err != nil {
+			return fmt.Errorf("set hostname %s error %v", config.Hostname, err)
+		}
+	}
+	// 用户在切换根目录后按容器内的/etc/passwd、/etc/group解析
+	var execUser *ExecUser
+	if config.User != "" {
+		if execUser, err = LookupUser(config.User, "/etc/passwd", "/etc/group"); err != nil {
+			return err
+		}
+	}
+	// 工作目录不存在时创建，切换用户前创建以免没有权限
+	if config.Cwd != "" {
+		if err := os.MkdirAll(config.Cwd, 0755); err != nil {
+			return fmt.Errorf("mkdir workdir %s error %v", config.Cwd, err)
+		}
+		if err := os.Chdir(config.Cwd); err != nil {
+			return fmt.Errorf("chdir to %s error %v", config.Cwd, err)
+		}
+	}
+	if execUser != nil && !hasEnv(config.Env, "HOME") {
+		config.Env = append(config.Env, "HOME="+execUser.Home)
+	}
+
+	// LookPath使用当前进程的PATH查找命令，先换成容器的环境变量
+	os.Clearenv()
+	for _, e := range config.Env {
+		if kv := strings.SplitN(e, "=", 2); len(kv) == 2 {
+			os.Setenv(kv[0], kv[1])
+		}
+	}
+	// 在PATH环境变量内搜索Args[0]，并返回绝对路径或者时一个相对于当前目录的相对路径
+	path, err := exec.LookPath(config.Args[0])
+	if err != nil {
+		return fmt.Errorf("exec look path error %v", err)
+	}
 	log.Infof("Find path %s", path)
-	if err := syscall.Exec(path, commandArray, os.Environ()); err != nil {
-		log.Errorf(err.Error())
+	// 切换用户后进程不再可dump，无法打开/proc/self/fd，需要先等待fifo
+	if execFifo >= 0 {
+		if err := waitExecFifo(execFifo, reply); err != nil {
+			return err
+		}
+	}
+	if execUser != nil {
+		if err := setupUser(execUser); err != nil {
+			return err
+		}
+	}
+	if config.Init {
+		return runAsInit(path, config.Args, config.Env, reply)
+	}
+	if err := syscall.Exec(path, config.Args, config.Env); err != nil {
+		return fmt.Errorf("exec %s error %v", path, err)
 	}
 	return nil
 }
 
-func ReadUserCommand() []string {
-	pipe := os.NewFile(uintptr(3), "pipe")
-	defer pipe.Close()
-	msg, err := ioutil.ReadAll(pipe)
+// 环境变量中是否已经设置了key
+func hasEnv(env []string, key string) bool {
+	for _, e := range env {
+		if strings.SplitN(e, "=", 2)[0] == key {
+			return true
+		}
+	}
+	return false
+}
+
+// oci create的容器在exec用户命令前通知父进程已经就绪，然后阻塞在fifo上，直到oci start打开fifo读取
+func waitExecFifo(execFifo int, reply *os.File) error {
+	reply.Close()
+	fd, err := unix.Open(fmt.Sprintf("/proc/self/fd/%d", execFifo), unix.O_WRONLY|unix.O_CLOEXEC, 0)
 	if err != nil {
-		log.Errorf("init read pipe error %v", err)
-		return nil
+		return fmt.Errorf("open exec fifo error %v", err)
+	}
+	defer unix.Close(fd)
+	if _, err := unix.Write(fd, []byte("0")); err != nil {
+		return fmt.Errorf("write exec fifo error %v", err)
 	}
-	msgStr := string(msg)
-	return strings.Split(msgStr, " ")
+	unix.Close(execFifo)
+	return nil
 }
 
-func setupMount(containerName string) {
-	mnt := fmt.Sprintf(vars.MntDir, containerName)
-	chroot(mnt)
+func setupMount(config *InitConfig) error {
+	// 容器中的挂载不能传播回主机
+	if err := mount.MakeRSlave("/"); err != nil {
+		return fmt.Errorf("make / rslave error %v", err)
+	}
+
+	// bind挂载的源目录在主机上，需要在切换根目录之前挂载到rootfs中
+	var mounts []Mount
+	for _, m := range config.Mounts {
+		if m.Type == "bind" || m.Flags&syscall.MS_BIND != 0 {
+			if err := bindMount(config.Rootfs, m); err != nil {
+				return err
+			}
+			continue
+		}
+		mounts = append(mounts, m)
+	}
+
+	if err := chroot(config.Rootfs); err != nil {
+		return fmt.Errorf("change root to %s error %v", config.Rootfs, err)
+	}
+
+	for _, m := range mounts {
+		// cgroup的挂载需要按cgroup版本分别处理，暂不支持
+		if m.Type == "cgroup" || m.Type == "cgroup2" {
+			log.Warnf("mount %s type %s is not supported, skipped", m.Destination, m.Type)
+			continue
+		}
+		if err := os.MkdirAll(m.Destination, 0755); err != nil {
+			return fmt.Errorf("mkdir %s error: %v", m.Destination, err)
+		}
+		if err := syscall.Mount(m.Source, m.Destination, m.Type, uintptr(m.Flags), m.Data); err != nil {
+			return fmt.Errorf("mount %s error: %v", m.Destination, err)
+		}
+	}
+
+	if config.ReadonlyRootfs {
+		if err := syscall.Mount("", "/", "", syscall.MS_BIND|syscall.MS_REMOUNT|syscall.MS_RDONLY, ""); err != nil {
+			return fmt.Errorf("remount rootfs readonly error %v", err)
+		}
+	}
+	return nil
+}
 
-	// mount proc
-	defaultMountFlags := syscall.MS_NOEXEC | syscall.MS_NOSUID | syscall.MS_NODEV
-	err := syscall.Mount("proc", "/proc", "proc", uintptr(defaultMountFlags), "")
+// 将主机上的文件或目录bind挂载到rootfs中，只读挂载需要再remount一次才能生效
+func bindMount(rootfs string, m Mount) error {
+	dest := filepath.Join(rootfs, m.Destination)
+	info, err := os.Stat(m.Source)
 	if err != nil {
-		log.Errorf("mount proc error: %v", err)
+		return fmt.Errorf("bind mount source %s error %v", m.Source, err)
+	}
+	if info.IsDir() {
+		err = os.MkdirAll(dest, 0755)
+	} else if err = os.MkdirAll(filepath.Dir(dest), 0755); err == nil {
+		var f *os.File
+		if f, err = os.OpenFile(dest, os.O_CREATE, 0644); err == nil {
+			f.Close()
+		}
 	}
-	err = syscall.Mount("tmpfs", "/dev", "tmpfs", syscall.MS_NOSUID|syscall.MS_STRICTATIME, "mode=755")
 	if err != nil {
-		log.Errorf("mount dev error: %v", err)
+		return fmt.Errorf("create bind mount point %s error %v", dest, err)
+	}
+
+	flags := uintptr(m.Flags | syscall.MS_BIND)
+	if err := syscall.Mount(m.Source, dest, "bind", flags, ""); err != nil {
+		return fmt.Errorf("bind mount %s error %v", m.Destination, err)
 	}
+	if m.Flags&syscall.MS_RDONLY != 0 {
+		if err := syscall.Mount("", dest, "", flags|syscall.MS_REMOUNT, ""); err != nil {
+			return fmt.Errorf("remount %s readonly error %v", m.Destination, err)
+		}
+	}
+	return nil
 }
 
 // 切换根目录（直接引用docker-ce源码，是最重要最核心的一段代码！！！！！！！！！！！)
@@ -0,0 +1,98 @@
+package container
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"syscall"
+)
+
+/*
+	run(shim)进程与容器init进程之间的通信：
+	1. fd 3: 配置管道，父进程写入json格式的InitConfig后关闭写端
+	2. fd 4: 结果管道，init进程设置了CLOEXEC，exec用户命令成功后自动关闭，父进程读到EOF即启动成功；
+	   失败时init进程写入错误信息后退出
+*/
+
+// 没有指定PATH时容器使用的默认值，与docker保持一致
+const defaultPath = "PATH=/usr/local/sbin:/usr/local/bin:/usr/sbin:/usr/bin:/sbin:/bin"
+
+// InitConfig 容器init进程运行用户命令所需的配置
+type InitConfig struct {
+	Args     []string `json:"args"`     // 用户命令及参数
+	Env      []string `json:"env"`      // 用户命令的环境变量
+	Cwd      string   `json:"cwd"`      // 用户命令的工作目录，为空时为/
+	User     string   `json:"user"`     // 运行用户命令的用户
+	Hostname string   `json:"hostname"` // 容器的主机名，为空时不设置
+	Mounts   []Mount  `json:"mounts"`   // 在容器内挂载的文件系统，bind挂载在切换根目录前完成
+	Init     bool     `json:"init"`     // 是否保留mydocker init作为1号进程
+
+	Rootfs         string `json:"rootfs"`                   // 容器根文件系统在主机上的路径
+	ReadonlyRootfs bool   `json:"readonlyRootfs,omitempty"` // 挂载完成后将根文件系统重新挂载为只读
+	ExecFifo       string `json:"execFifo,omitempty"`       // 不为空时，init进程在exec用户命令前等待该fifo被读取(oci start)
+}
+
+// Mount 容器内的一个挂载点
+type Mount struct {
+	Source      string `json:"source"`
+	Destination string `json:"destination"`
+	Type        string `json:"type"`
+	Flags       int    `json:"flags"`
+	Data        string `json:"data"`
+}
+
+// DefaultMounts 容器内默认挂载的/proc和/dev
+func DefaultMounts() []Mount {
+	return []Mount{
+		{Source: "proc", Destination: "/proc", Type: "proc", Flags: syscall.MS_NOEXEC | syscall.MS_NOSUID | syscall.MS_NODEV},
+		{Source: "tmpfs", Destination: "/dev", Type: "tmpfs", Flags: syscall.MS_NOSUID | syscall.MS_STRICTATIME, Data: "mode=755"},
+	}
+}
+
+// WithDefaultPath 环境变量中没有PATH时补上默认值，否则容器内找不到用户命令
+func WithDefaultPath(env []string) []string {
+	for _, e := range env {
+		if strings.HasPrefix(e, "PATH=") {
+			return env
+		}
+	}
+	return append(append([]string{}, env...), defaultPath)
+}
+
+// SendInitConfig 将配置写入配置管道并关闭写端
+func SendInitConfig(pipe *os.File, config *InitConfig) error {
+	defer pipe.Close()
+	if err := json.NewEncoder(pipe).Encode(config); err != nil {
+		return fmt.Errorf("send init config error %v", err)
+	}
+	return nil
+}
+
+// ReadInitConfig init进程从fd 3读取配置
+func ReadInitConfig() (*InitConfig, error) {
+	pipe := os.NewFile(uintptr(3), "pipe")
+	defer pipe.Close()
+	config := &InitConfig{}
+	if err := json.NewDecoder(pipe).Decode(config); err != nil {
+		return nil, fmt.Errorf("init read config error %v", err)
+	}
+	if len(config.Args) == 0 {
+		return nil, fmt.Errorf("run container get user command error, args is empty")
+	}
+	return config, nil
+}
+
+// WaitInitReply 父进程等待init进程的启动结果，读到EOF表示用户命令已经exec成功
+func WaitInitReply(pipe *os.File) error {
+	defer pipe.Close()
+	msg, err := io.ReadAll(pipe)
+	if err != nil {
+		return fmt.Errorf("read init reply error %v", err)
+	}
+	if len(msg) > 0 {
+		return fmt.Errorf("container init error: %s", msg)
+	}
+	return nil
+}
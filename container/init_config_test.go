@@ -0,0 +1,37 @@
+package container
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestInitConfigRoundTrip(t *testing.T) {
+	config := &InitConfig{
+		Args:   []string{"sh", "-c", "echo \"hello world\"", ""},
+		Env:    []string{"A=b c"},
+		Cwd:    "/tmp",
+		Mounts: DefaultMounts(),
+		Init:   true,
+	}
+	data, err := json.Marshal(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := &InitConfig{}
+	if err := json.Unmarshal(data, got); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(config, got) {
+		t.Errorf("want %+v, got %+v", config, got)
+	}
+}
+
+func TestWithDefaultPath(t *testing.T) {
+	if env := WithDefaultPath([]string{"A=1"}); len(env) != 2 || env[1] != defaultPath {
+		t.Errorf("default PATH not added: %v", env)
+	}
+	if env := WithDefaultPath([]string{"PATH=/bin"}); len(env) != 1 {
+		t.Errorf("PATH should not be overridden: %v", env)
+	}
+}
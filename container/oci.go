@@ -0,0 +1,292 @@
+package container
+
+import (
+	"encoding/json"
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"mydocker/cgroups/subsystems"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+/*
+	OCI runtime-spec bundle的支持：bundle目录下的config.json描述容器，root.path为容器的根文件系统。
+	这里只定义了mydocker用得到的字段，读取后转换为Spec，其余字段忽略
+*/
+
+// OCIVersion 实现的OCI runtime-spec版本
+const OCIVersion = "1.0.2"
+
+type OCISpec struct {
+	OCIVersion  string            `json:"ociVersion"`
+	Process     *OCIProcess       `json:"process,omitempty"`
+	Root        *OCIRoot          `json:"root,omitempty"`
+	Hostname    string            `json:"hostname,omitempty"`
+	Mounts      []OCIMount        `json:"mounts,omitempty"`
+	Linux       *OCILinux         `json:"linux,omitempty"`
+	Annotations map[string]string `json:"annotations,omitempty"`
+}
+
+type OCIProcess struct {
+	Terminal    bool     `json:"terminal,omitempty"`
+	User        OCIUser  `json:"user"`
+	Args        []string `json:"args,omitempty"`
+	Env         []string `json:"env,omitempty"`
+	Cwd         string   `json:"cwd"`
+	OOMScoreAdj *int     `json:"oomScoreAdj,omitempty"`
+}
+
+type OCIUser struct {
+	UID uint32 `json:"uid"`
+	GID uint32 `json:"gid"`
+}
+
+type OCIRoot struct {
+	Path     string `json:"path"`
+	Readonly bool   `json:"readonly,omitempty"`
+}
+
+type OCIMount struct {
+	Destination string   `json:"destination"`
+	Type        string   `json:"type,omitempty"`
+	Source      string   `json:"source,omitempty"`
+	Options     []string `json:"options,omitempty"`
+}
+
+type OCILinux struct {
+	Namespaces  []OCINamespace `json:"namespaces,omitempty"`
+	Resources   *OCIResources  `json:"resources,omitempty"`
+	CgroupsPath string         `json:"cgroupsPath,omitempty"`
+}
+
+type OCINamespace struct {
+	Type string `json:"type"`
+	Path string `json:"path,omitempty"`
+}
+
+type OCIResources struct {
+	Memory *struct {
+		Limit            *int64 `json:"limit,omitempty"`
+		Reservation      *int64 `json:"reservation,omitempty"`
+		Swap             *int64 `json:"swap,omitempty"`
+		Kernel           *int64 `json:"kernel,omitempty"`
+		DisableOOMKiller *bool  `json:"disableOOMKiller,omitempty"`
+	} `json:"memory,omitempty"`
+	CPU *struct {
+		Shares *uint64 `json:"shares,omitempty"`
+		Quota  *int64  `json:"quota,omitempty"`
+		Period *uint64 `json:"period,omitempty"`
+		Cpus   string  `json:"cpus,omitempty"`
+		Mems   string  `json:"mems,omitempty"`
+	} `json:"cpu,omitempty"`
+	Pids *struct {
+		Limit int64 `json:"limit"`
+	} `json:"pids,omitempty"`
+	BlockIO *struct {
+		Weight *uint16 `json:"weight,omitempty"`
+	} `json:"blockIO,omitempty"`
+}
+
+// OCIState `oci state`输出的容器状态
+type OCIState struct {
+	OCIVersion  string            `json:"ociVersion"`
+	ID          string            `json:"id"`
+	Status      string            `json:"status"`
+	Pid         int               `json:"pid,omitempty"`
+	Bundle      string            `json:"bundle"`
+	Annotations map[string]string `json:"annotations,omitempty"`
+}
+
+// 命名空间类型与clone flag的对应关系
+var namespaceFlags = map[string]uintptr{
+	"pid":     syscall.CLONE_NEWPID,
+	"network": syscall.CLONE_NEWNET,
+	"mount":   syscall.CLONE_NEWNS,
+	"ipc":     syscall.CLONE_NEWIPC,
+	"uts":     syscall.CLONE_NEWUTS,
+	"cgroup":  syscall.CLONE_NEWCGROUP,
+}
+
+// 没有指定命名空间时容器使用的命名空间
+var defaultNamespaces = []string{"uts", "pid", "mount", "network", "ipc"}
+
+// CloneFlags 根据命名空间列表计算clone flag，为空时使用默认的命名空间。pivot_root需要独立的mount命名空间，总是开启
+func CloneFlags(namespaces []string) (uintptr, error) {
+	if len(namespaces) == 0 {
+		namespaces = defaultNamespaces
+	}
+	flags := uintptr(syscall.CLONE_NEWNS)
+	for _, ns := range namespaces {
+		flag, ok := namespaceFlags[ns]
+		if !ok {
+			return 0, fmt.Errorf("namespace %s is not supported", ns)
+		}
+		flags |= flag
+	}
+	return flags, nil
+}
+
+// LoadOCIBundle 读取bundle目录下的config.json
+func LoadOCIBundle(bundle string) (*OCISpec, error) {
+	content, err := os.ReadFile(filepath.Join(bundle, "config.json"))
+	if err != nil {
+		return nil, fmt.Errorf("read bundle config error %v", err)
+	}
+	ociSpec := &OCISpec{}
+	if err := json.Unmarshal(content, ociSpec); err != nil {
+		return nil, fmt.Errorf("parse bundle config error %v", err)
+	}
+	return ociSpec, nil
+}
+
+// ToSpec 将OCI配置转换为容器的Spec，bundle为bundle目录的绝对路径
+func (o *OCISpec) ToSpec(id, bundle string) (*Spec, error) {
+	if o.Process == nil || len(o.Process.Args) == 0 {
+		return nil, fmt.Errorf("process.args is required")
+	}
+	if o.Root == nil || o.Root.Path == "" {
+		return nil, fmt.Errorf("root.path is required")
+	}
+
+	rootfs := o.Root.Path
+	if !filepath.IsAbs(rootfs) {
+		rootfs = filepath.Join(bundle, rootfs)
+	}
+	spec := &Spec{
+		Name:           id,
+		Command:        o.Process.Args,
+		Env:            o.Process.Env,
+		Tty:            o.Process.Terminal,
+		Workdir:        o.Process.Cwd,
+		Hostname:       o.Hostname,
+		Rootfs:         rootfs,
+		ReadonlyRootfs: o.Root.Readonly,
+		Resources:      &subsystems.ResourceConfig{},
+	}
+	if o.Process.User.UID != 0 || o.Process.User.GID != 0 {
+		spec.User = fmt.Sprintf("%d:%d", o.Process.User.UID, o.Process.User.GID)
+	}
+	if o.Process.OOMScoreAdj != nil {
+		spec.Resources.OomScoreAdj = strconv.Itoa(*o.Process.OOMScoreAdj)
+	}
+
+	for _, m := range o.Mounts {
+		flags, data := parseMountOptions(m.Options)
+		// 只给了bind选项而没有type时，按bind挂载处理
+		if m.Type == "" {
+			m.Type = "bind"
+		}
+		// bind挂载的相对路径相对于bundle目录
+		if (m.Type == "bind" || flags&syscall.MS_BIND != 0) && !filepath.IsAbs(m.Source) {
+			m.Source = filepath.Join(bundle, m.Source)
+		}
+		spec.Mounts = append(spec.Mounts, Mount{Source: m.Source, Destination: m.Destination, Type: m.Type, Flags: flags, Data: data})
+	}
+
+	if o.Linux != nil {
+		for _, ns := range o.Linux.Namespaces {
+			if ns.Path != "" {
+				return nil, fmt.Errorf("joining existing %s namespace is not supported", ns.Type)
+			}
+			if _, ok := namespaceFlags[ns.Type]; !ok {
+				return nil, fmt.Errorf("namespace %s is not supported", ns.Type)
+			}
+			spec.Namespaces = append(spec.Namespaces, ns.Type)
+		}
+		// cgroupsPath为相对于cgroup根目录的路径，systemd格式(slice:prefix:name)不支持
+		if cgroupsPath := o.Linux.CgroupsPath; cgroupsPath != "" {
+			if strings.Contains(cgroupsPath, ":") {
+				return nil, fmt.Errorf("systemd cgroupsPath %s is not supported", cgroupsPath)
+			}
+			spec.CgroupPath = strings.Trim(cgroupsPath, "/")
+		}
+		setOCIResources(spec.Resources, o.Linux.Resources)
+	}
+	return spec, nil
+}
+
+// 将OCI的linux.resources转换为ResourceConfig
+func setOCIResources(res *subsystems.ResourceConfig, r *OCIResources) {
+	if r == nil {
+		return
+	}
+	formatInt := func(v *int64) string {
+		if v == nil {
+			return ""
+		}
+		return strconv.FormatInt(*v, 10)
+	}
+	formatUint := func(v *uint64) string {
+		if v == nil {
+			return ""
+		}
+		return strconv.FormatUint(*v, 10)
+	}
+
+	if m := r.Memory; m != nil {
+		res.MemoryLimit = formatInt(m.Limit)
+		res.MemoryReservation = formatInt(m.Reservation)
+		res.MemorySwap = formatInt(m.Swap)
+		res.KernelMemory = formatInt(m.Kernel)
+		res.OomKillDisable = m.DisableOOMKiller
+	}
+	if c := r.CPU; c != nil {
+		res.CpuShare = formatUint(c.Shares)
+		res.CpuQuota = formatInt(c.Quota)
+		res.CpuPeriod = formatUint(c.Period)
+		res.CpuSet = c.Cpus
+		if c.Mems != "" {
+			log.Warnf("linux.resources.cpu.mems is not supported, ignored")
+		}
+	}
+	if r.Pids != nil {
+		res.PidsLimit = strconv.FormatInt(r.Pids.Limit, 10)
+	}
+	if r.BlockIO != nil && r.BlockIO.Weight != nil {
+		res.BlkioWeight = strconv.Itoa(int(*r.BlockIO.Weight))
+	}
+}
+
+// 挂载选项与mount flag的对应关系，其余选项作为data传给文件系统
+var mountOptionFlags = map[string]struct {
+	clear bool
+	flag  int
+}{
+	"ro":          {false, syscall.MS_RDONLY},
+	"rw":          {true, syscall.MS_RDONLY},
+	"nosuid":      {false, syscall.MS_NOSUID},
+	"suid":        {true, syscall.MS_NOSUID},
+	"nodev":       {false, syscall.MS_NODEV},
+	"dev":         {true, syscall.MS_NODEV},
+	"noexec":      {false, syscall.MS_NOEXEC},
+	"exec":        {true, syscall.MS_NOEXEC},
+	"bind":        {false, syscall.MS_BIND},
+	"rbind":       {false, syscall.MS_BIND | syscall.MS_REC},
+	"relatime":    {false, syscall.MS_RELATIME},
+	"noatime":     {false, syscall.MS_NOATIME},
+	"strictatime": {false, syscall.MS_STRICTATIME},
+	"private":     {false, syscall.MS_PRIVATE},
+	"rprivate":    {false, syscall.MS_PRIVATE | syscall.MS_REC},
+	"slave":       {false, syscall.MS_SLAVE},
+	"rslave":      {false, syscall.MS_SLAVE | syscall.MS_REC},
+}
+
+func parseMountOptions(options []string) (int, string) {
+	flags := 0
+	var data []string
+	for _, o := range options {
+		if f, ok := mountOptionFlags[o]; ok {
+			if f.clear {
+				flags &^= f.flag
+			} else {
+				flags |= f.flag
+			}
+			continue
+		}
+		data = append(data, o)
+	}
+	return flags, strings.Join(data, ",")
+}
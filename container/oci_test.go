@@ -0,0 +1,54 @@
+package container
+
+import (
+	"encoding/json"
+	"syscall"
+	"testing"
+)
+
+func TestOCISpecToSpec(t *testing.T) {
+	content := `{
+	"ociVersion": "1.0.2",
+	"process": {"user": {"uid": 1000, "gid": 100}, "args": ["sh"], "cwd": "/"},
+	"root": {"path": "rootfs", "readonly": true},
+	"hostname": "oci",
+	"mounts": [
+		{"destination": "/proc", "type": "proc", "source": "proc"},
+		{"destination": "/data", "type": "bind", "source": "data", "options": ["rbind", "ro"]}
+	],
+	"linux": {
+		"namespaces": [{"type": "pid"}, {"type": "uts"}, {"type": "mount"}],
+		"resources": {"pids": {"limit": 50}},
+		"cgroupsPath": "/mydocker/oci"
+	}
+}`
+	ociSpec := &OCISpec{}
+	if err := json.Unmarshal([]byte(content), ociSpec); err != nil {
+		t.Fatal(err)
+	}
+	spec, err := ociSpec.ToSpec("oci", "/bundle")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if spec.Rootfs != "/bundle/rootfs" || !spec.ReadonlyRootfs || spec.User != "1000:100" ||
+		spec.CgroupPath != "mydocker/oci" || spec.Resources.PidsLimit != "50" {
+		t.Errorf("unexpected spec %+v", spec)
+	}
+	if len(spec.Mounts) != 2 || spec.Mounts[1].Source != "/bundle/data" ||
+		spec.Mounts[1].Flags != syscall.MS_BIND|syscall.MS_REC|syscall.MS_RDONLY {
+		t.Errorf("unexpected mounts %+v", spec.Mounts)
+	}
+
+	// 加入已有的命名空间不支持
+	ociSpec.Linux.Namespaces = append(ociSpec.Linux.Namespaces, OCINamespace{Type: "network", Path: "/proc/1/ns/net"})
+	if _, err := ociSpec.ToSpec("oci", "/bundle"); err == nil {
+		t.Errorf("namespace path should be rejected")
+	}
+}
+
+func TestParseMountOptions(t *testing.T) {
+	flags, data := parseMountOptions([]string{"nosuid", "ro", "rw", "mode=755", "size=65536k"})
+	if flags != syscall.MS_NOSUID || data != "mode=755,size=65536k" {
+		t.Errorf("unexpected flags %x data %s", flags, data)
+	}
+}
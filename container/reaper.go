@@ -0,0 +1,73 @@
+package container
+
+import (
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/sys/unix"
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// runAsInit 以--init方式运行容器时，mydocker init保留为容器的1号进程：
+// 启动用户命令作为子进程，把收到的信号转发给它，回收所有孤儿进程，并以用户命令的退出码退出
+func runAsInit(path string, args []string, env []string, reply *os.File) error {
+	signals := make(chan os.Signal, 32)
+	signal.Notify(signals)
+
+	attr := &os.ProcAttr{
+		Env:   env,
+		Files: []*os.File{os.Stdin, os.Stdout, os.Stderr},
+		Sys:   &syscall.SysProcAttr{},
+	}
+	// -ti时让子进程成为终端的前台进程组，Ctrl-C只发给用户命令，避免init再转发一次
+	if _, err := unix.IoctlGetTermios(0, unix.TCGETS); err == nil {
+		attr.Sys.Foreground = true
+		attr.Sys.Ctty = 0
+	}
+	child, err := os.StartProcess(path, args, attr)
+	if err != nil {
+		signal.Reset()
+		return fmt.Errorf("start %s error %v", path, err)
+	}
+	// 用户命令已经启动，通知父进程
+	reply.Close()
+
+	for sig := range signals {
+		switch sig {
+		case syscall.SIGCHLD:
+			if exitCode, exited := reapChildren(child.Pid); exited {
+				os.Exit(exitCode)
+			}
+		// SIGURG是go运行时用于抢占调度的信号，不需要转发
+		case syscall.SIGURG:
+		default:
+			if err := child.Signal(sig); err != nil {
+				log.Warnf("Forward signal %v to %d error %v", sig, child.Pid, err)
+			}
+		}
+	}
+	return nil
+}
+
+// 回收所有已退出的子进程(包括过继给1号进程的孤儿进程)，返回用户命令是否已经退出及其退出码
+func reapChildren(childPid int) (int, bool) {
+	exitCode, exited := 0, false
+	for {
+		var status syscall.WaitStatus
+		pid, err := syscall.Wait4(-1, &status, syscall.WNOHANG, nil)
+		if err == syscall.EINTR {
+			continue
+		}
+		if pid <= 0 || err != nil {
+			return exitCode, exited
+		}
+		if pid == childPid {
+			exited = true
+			exitCode = status.ExitStatus()
+			if status.Signaled() {
+				exitCode = 128 + int(status.Signal())
+			}
+		}
+	}
+}
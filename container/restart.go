@@ -0,0 +1,66 @@
+package container
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+const (
+	RestartPolicyNo            = "no"
+	RestartPolicyAlways        = "always"
+	RestartPolicyOnFailure     = "on-failure"
+	RestartPolicyUnlessStopped = "unless-stopped"
+)
+
+// RestartPolicy 容器退出后的重启策略，格式为 no|on-failure[:N]|always|unless-stopped
+type RestartPolicy struct {
+	Name string
+	// on-failure时最多重启的次数，0表示不限制
+	MaximumRetryCount int
+}
+
+// ParseRestartPolicy 解析--restart参数，空字符串等同于no
+func ParseRestartPolicy(policy string) (RestartPolicy, error) {
+	p := RestartPolicy{Name: RestartPolicyNo}
+	if policy == "" {
+		return p, nil
+	}
+
+	parts := strings.SplitN(policy, ":", 2)
+	p.Name = parts[0]
+	switch p.Name {
+	case RestartPolicyNo, RestartPolicyAlways, RestartPolicyUnlessStopped:
+		if len(parts) == 2 {
+			return p, fmt.Errorf("maximum retry count cannot be used with restart policy '%s'", p.Name)
+		}
+	case RestartPolicyOnFailure:
+		if len(parts) == 2 {
+			count, err := strconv.Atoi(parts[1])
+			if err != nil || count < 0 {
+				return p, fmt.Errorf("invalid maximum retry count %s", parts[1])
+			}
+			p.MaximumRetryCount = count
+		}
+	default:
+		return p, fmt.Errorf("invalid restart policy '%s'", policy)
+	}
+	return p, nil
+}
+
+// ShouldRestart 根据退出码、是否被手动停止以及已重启次数判断是否需要重启容器
+func (p RestartPolicy) ShouldRestart(exitCode int, manuallyStopped bool, restartCount int) bool {
+	if manuallyStopped {
+		return false
+	}
+	switch p.Name {
+	case RestartPolicyAlways, RestartPolicyUnlessStopped:
+		return true
+	case RestartPolicyOnFailure:
+		if exitCode == 0 {
+			return false
+		}
+		return p.MaximumRetryCount == 0 || restartCount < p.MaximumRetryCount
+	}
+	return false
+}
@@ -0,0 +1,42 @@
+package container
+
+import "testing"
+
+func TestParseRestartPolicy(t *testing.T) {
+	cases := map[string]RestartPolicy{
+		"":               {Name: RestartPolicyNo},
+		"no":             {Name: RestartPolicyNo},
+		"always":         {Name: RestartPolicyAlways},
+		"unless-stopped": {Name: RestartPolicyUnlessStopped},
+		"on-failure":     {Name: RestartPolicyOnFailure},
+		"on-failure:3":   {Name: RestartPolicyOnFailure, MaximumRetryCount: 3},
+	}
+	for policy, want := range cases {
+		got, err := ParseRestartPolicy(policy)
+		if err != nil || got != want {
+			t.Errorf("ParseRestartPolicy(%q) = %+v, %v; want %+v", policy, got, err, want)
+		}
+	}
+
+	for _, policy := range []string{"sometimes", "always:3", "on-failure:x", "on-failure:-1"} {
+		if _, err := ParseRestartPolicy(policy); err == nil {
+			t.Errorf("ParseRestartPolicy(%q) should fail", policy)
+		}
+	}
+}
+
+func TestShouldRestart(t *testing.T) {
+	onFailure := RestartPolicy{Name: RestartPolicyOnFailure, MaximumRetryCount: 2}
+	if onFailure.ShouldRestart(0, false, 0) {
+		t.Errorf("on-failure should not restart a successful container")
+	}
+	if !onFailure.ShouldRestart(1, false, 1) {
+		t.Errorf("on-failure should restart before reaching the retry limit")
+	}
+	if onFailure.ShouldRestart(1, false, 2) {
+		t.Errorf("on-failure should stop restarting at the retry limit")
+	}
+	if (RestartPolicy{Name: RestartPolicyAlways}).ShouldRestart(0, true, 0) {
+		t.Errorf("manually stopped container should not be restarted")
+	}
+}
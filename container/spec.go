@@ -0,0 +1,157 @@
+package container
+
+import (
+	"encoding/json"
+	"fmt"
+	"gopkg.in/yaml.v3"
+	"mydocker/cgroups/subsystems"
+	"mydocker/utils"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+/*
+Spec 描述一个容器的全部配置，run的命令行参数会填充到Spec中，也可以通过--config从json/yaml文件加载，
+如:
+
+	name: web
+	image: busybox
+	command: ["sh", "-c", "httpd -f -p 80"]
+	env: ["PORT=80"]
+	network: mynet
+	ports: ["8080:80"]
+	restartPolicy: on-failure:3
+	resources:
+	  memoryLimit: 64m
+
+容器创建时完整的Spec保存在容器目录下的spec.json中
+*/
+type Spec struct {
+	Name          string                     `json:"name,omitempty" yaml:"name,omitempty"`                   // 容器名称，为空时使用容器id
+	Image         string                     `json:"image" yaml:"image"`                                     // 镜像名称
+	Command       []string                   `json:"command" yaml:"command"`                                 // 容器命令及参数
+	Env           []string                   `json:"env,omitempty" yaml:"env,omitempty"`                     // 环境变量，KEY=VALUE
+	Volumes       []string                   `json:"volumes,omitempty" yaml:"volumes,omitempty"`             // 数据卷，主机目录:容器目录
+	Tty           bool                       `json:"tty,omitempty" yaml:"tty,omitempty"`                     // 是否以交互方式运行
+	Hostname      string                     `json:"hostname,omitempty" yaml:"hostname,omitempty"`           // 主机名
+	User          string                     `json:"user,omitempty" yaml:"user,omitempty"`                   // 运行用户命令的用户
+	Workdir       string                     `json:"workdir,omitempty" yaml:"workdir,omitempty"`             // 用户命令的工作目录
+	Network       string                     `json:"network,omitempty" yaml:"network,omitempty"`             // 连接的网络
+	Ports         []string                   `json:"ports,omitempty" yaml:"ports,omitempty"`                 // 端口映射，主机端口:容器端口
+	RestartPolicy string                     `json:"restartPolicy,omitempty" yaml:"restartPolicy,omitempty"` // 重启策略
+	StopSignal    string                     `json:"stopSignal,omitempty" yaml:"stopSignal,omitempty"`       // stop时发送的信号
+	Init          bool                       `json:"init,omitempty" yaml:"init,omitempty"`                   // 是否使用mydocker init作为1号进程
+	Resources     *subsystems.ResourceConfig `json:"resources,omitempty" yaml:"resources,omitempty"`         // 资源限制
+
+	// 以下主要用于OCI bundle
+	Rootfs         string   `json:"rootfs,omitempty" yaml:"rootfs,omitempty"`                 // 直接使用的根文件系统目录，指定时不使用镜像
+	ReadonlyRootfs bool     `json:"readonlyRootfs,omitempty" yaml:"readonlyRootfs,omitempty"` // 根文件系统只读
+	Mounts         []Mount  `json:"mounts,omitempty" yaml:"mounts,omitempty"`                 // 容器内的挂载，为空时挂载默认的/proc和/dev
+	Namespaces     []string `json:"namespaces,omitempty" yaml:"namespaces,omitempty"`         // 使用的命名空间，为空时使用默认的命名空间
+	CgroupPath     string   `json:"cgroupPath,omitempty" yaml:"cgroupPath,omitempty"`         // cgroup路径，为空时为mydocker/<容器ID>
+}
+
+// LoadSpec 从文件加载Spec，.yaml/.yml按yaml解析，其他按json解析
+func LoadSpec(file string) (*Spec, error) {
+	content, err := os.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("read spec %s error %v", file, err)
+	}
+	spec := &Spec{}
+	switch strings.ToLower(filepath.Ext(file)) {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(content, spec)
+	default:
+		err = json.Unmarshal(content, spec)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("parse spec %s error %v", file, err)
+	}
+	return spec, nil
+}
+
+// SaveSpec 将Spec保存到文件
+func SaveSpec(file string, spec *Spec) error {
+	content, err := json.MarshalIndent(spec, "", "  ")
+	if err != nil {
+		return fmt.Errorf("marshal spec error %v", err)
+	}
+	if err := os.WriteFile(file, content, 0644); err != nil {
+		return fmt.Errorf("write spec %s error %v", file, err)
+	}
+	return nil
+}
+
+// Validate 检查Spec，并为没有指定的项设置默认值
+func (s *Spec) Validate() error {
+	if s.Image == "" && s.Rootfs == "" {
+		return fmt.Errorf("missing image")
+	}
+	// 使用镜像时命令可以来自镜像的config，创建容器时再检查
+	if len(s.Command) == 0 && s.Rootfs != "" {
+		return fmt.Errorf("missing container command")
+	}
+	if s.RestartPolicy == "" {
+		s.RestartPolicy = RestartPolicyNo
+	}
+	if _, err := ParseRestartPolicy(s.RestartPolicy); err != nil {
+		return err
+	}
+	if s.StopSignal == "" {
+		s.StopSignal = "SIGTERM"
+	}
+	if _, err := utils.ParseSignal(s.StopSignal); err != nil {
+		return err
+	}
+	for _, volume := range s.Volumes {
+		if paths := volumePathExtract(volume); len(paths) != 2 || paths[0] == "" || paths[1] == "" {
+			return fmt.Errorf("invalid volume %s, should be host:container", volume)
+		}
+	}
+	if _, err := CloneFlags(s.Namespaces); err != nil {
+		return err
+	}
+	if s.Hostname != "" && !s.HasNamespace("uts") {
+		return fmt.Errorf("hostname requires a uts namespace")
+	}
+	if s.Workdir != "" && !filepath.IsAbs(s.Workdir) {
+		return fmt.Errorf("workdir %s should be an absolute path", s.Workdir)
+	}
+	if s.User != "" && strings.HasPrefix(s.User, ":") {
+		return fmt.Errorf("invalid user %s, should be user[:group] or uid[:gid]", s.User)
+	}
+	if s.Resources == nil {
+		s.Resources = &subsystems.ResourceConfig{}
+	}
+	return s.Resources.Validate()
+}
+
+// MergeEnv 合并两组KEY=VALUE形式的环境变量，同名时override中的生效
+func MergeEnv(base, override []string) []string {
+	overridden := map[string]bool{}
+	for _, e := range override {
+		overridden[strings.SplitN(e, "=", 2)[0]] = true
+	}
+	var env []string
+	for _, e := range base {
+		if !overridden[strings.SplitN(e, "=", 2)[0]] {
+			env = append(env, e)
+		}
+	}
+	return append(env, override...)
+}
+
+// HasNamespace 容器是否使用了指定的命名空间
+func (s *Spec) HasNamespace(ns string) bool {
+	namespaces := s.Namespaces
+	if len(namespaces) == 0 {
+		namespaces = defaultNamespaces
+	}
+	for _, n := range namespaces {
+		if n == ns {
+			return true
+		}
+	}
+	return false
+}
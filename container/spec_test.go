@@ -0,0 +1,77 @@
+package container
+
+import (
+	"mydocker/cgroups/subsystems"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestLoadSpec(t *testing.T) {
+	dir := t.TempDir()
+	yamlFile := filepath.Join(dir, "spec.yaml")
+	content := `
+name: web
+image: busybox
+command: ["sh", "-c", "echo hello world"]
+env:
+  - A=1
+ports: ["8080:80"]
+restartPolicy: on-failure:3
+resources:
+  memoryLimit: 64m
+  cpus: "1.5"
+`
+	if err := os.WriteFile(yamlFile, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	spec, err := LoadSpec(yamlFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := spec.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	if spec.Name != "web" || len(spec.Command) != 3 || spec.Command[2] != "echo hello world" ||
+		spec.Resources.MemoryLimit != "64m" || spec.Resources.Cpus != "1.5" || spec.StopSignal != "SIGTERM" {
+		t.Errorf("unexpected spec %+v", spec)
+	}
+
+	// 保存后以json重新加载，内容不变
+	jsonFile := filepath.Join(dir, "spec.json")
+	if err := SaveSpec(jsonFile, spec); err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := LoadSpec(jsonFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(spec, loaded) {
+		t.Errorf("want %+v, got %+v", spec, loaded)
+	}
+}
+
+func TestSpecValidate(t *testing.T) {
+	for _, spec := range []*Spec{
+		{Command: []string{"sh"}},
+		{Rootfs: "/tmp/rootfs"},
+		{Image: "busybox", Command: []string{"sh"}, RestartPolicy: "sometimes"},
+		{Image: "busybox", Command: []string{"sh"}, StopSignal: "NOPE"},
+		{Image: "busybox", Command: []string{"sh"}, Volumes: []string{"/tmp"}},
+		{Image: "busybox", Command: []string{"sh"}, Resources: &subsystems.ResourceConfig{BlkioWeight: "5"}},
+		{Image: "busybox", Command: []string{"sh"}, Resources: &subsystems.ResourceConfig{BlkioWeight: "2000"}},
+	} {
+		if err := spec.Validate(); err == nil {
+			t.Errorf("spec %+v should be invalid", spec)
+		}
+	}
+}
+
+func TestMergeEnv(t *testing.T) {
+	got := MergeEnv([]string{"A=1", "B=2"}, []string{"A=3", "C"})
+	want := []string{"B=2", "A=3", "C"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("want %v, got %v", want, got)
+	}
+}
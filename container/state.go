@@ -0,0 +1,94 @@
+package container
+
+import (
+	"fmt"
+	"mydocker/vars"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+/*
+	容器的状态流转：
+	created --> running <--> paused
+	             |   ^
+	             |   |
+	             |  restarting
+	             |   |
+	             v   v
+	         exited / dead --> removing
+	exited表示容器进程已退出并记录了退出码；dead表示进程已经不存在但没能记录退出码，或者清理容器时出错
+	restarting表示容器进程已退出，shim在退避时间后按重启策略重新启动，期间被stop则变为exited
+*/
+
+// SetCreated oci create后容器进程已经就绪，等待oci start运行用户命令
+func (c *ContainerInfo) SetCreated(pid int) {
+	c.Status = vars.CREATED
+	c.Pid = strconv.Itoa(pid)
+}
+
+// SetRunning 容器进程启动后记录pid和启动时间，并清除上一次运行的退出信息
+func (c *ContainerInfo) SetRunning(pid int) {
+	c.Status = vars.RUNNING
+	c.Pid = strconv.Itoa(pid)
+	c.StartedAt = time.Now().Format(vars.TimeFormat)
+	c.FinishedAt = ""
+	c.ExitCode = 0
+	c.OOMKilled = false
+}
+
+// SetExited 容器进程退出后记录退出码和退出时间
+func (c *ContainerInfo) SetExited(exitCode int) {
+	c.Status = vars.EXIT
+	c.Pid = ""
+	c.ExitCode = exitCode
+	c.FinishedAt = time.Now().Format(vars.TimeFormat)
+}
+
+// SetRestarting 容器进程退出后等待重启，记录本次的退出信息
+func (c *ContainerInfo) SetRestarting(exitCode int) {
+	c.SetExited(exitCode)
+	c.Status = vars.RESTARTING
+}
+
+// SetDead 容器进程已经不存在，但无法得知退出码
+func (c *ContainerInfo) SetDead() {
+	c.Status = vars.DEAD
+	c.Pid = ""
+	c.FinishedAt = time.Now().Format(vars.TimeFormat)
+}
+
+// IsRunning 容器进程是否仍在运行(包括被冻结)
+func (c *ContainerInfo) IsRunning() bool {
+	return c.Status == vars.RUNNING || c.Status == vars.PAUSED
+}
+
+// ExitCode 从进程的退出状态中获取退出码，被信号杀死时按shell惯例为128+信号值
+func ExitCode(state *os.ProcessState) int {
+	status, ok := state.Sys().(syscall.WaitStatus)
+	if !ok {
+		return state.ExitCode()
+	}
+	if status.Signaled() {
+		return 128 + int(status.Signal())
+	}
+	return status.ExitStatus()
+}
+
+// IsProcessAlive 判断进程是否存在，僵尸进程当作已经退出
+func IsProcessAlive(pid string) bool {
+	content, err := os.ReadFile(fmt.Sprintf("/proc/%s/stat", pid))
+	if err != nil || pid == "" {
+		return false
+	}
+	// /proc/<pid>/stat 格式为 "pid (comm) state ..."，comm中可能包含空格，从最后一个")"之后开始解析
+	stat := string(content)
+	idx := strings.LastIndex(stat, ")")
+	if idx < 0 {
+		return false
+	}
+	fields := strings.Fields(stat[idx+1:])
+	return len(fields) > 0 && fields[0] != "Z" && fields[0] != "X"
+}
@@ -0,0 +1,31 @@
+package container
+
+import (
+	"os"
+	"os/exec"
+	"strconv"
+	"testing"
+)
+
+func TestExitCode(t *testing.T) {
+	cmd := exec.Command("sh", "-c", "exit 3")
+	cmd.Run()
+	if code := ExitCode(cmd.ProcessState); code != 3 {
+		t.Errorf("want exit code 3, got %d", code)
+	}
+
+	cmd = exec.Command("sh", "-c", "kill -9 $$")
+	cmd.Run()
+	if code := ExitCode(cmd.ProcessState); code != 137 {
+		t.Errorf("want exit code 137, got %d", code)
+	}
+}
+
+func TestIsProcessAlive(t *testing.T) {
+	if !IsProcessAlive(strconv.Itoa(os.Getpid())) {
+		t.Errorf("current process should be alive")
+	}
+	if IsProcessAlive("") {
+		t.Errorf("empty pid should not be alive")
+	}
+}
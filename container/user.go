@@ -0,0 +1,149 @@
+package container
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// ExecUser 运行用户命令的用户
+type ExecUser struct {
+	Uid    int
+	Gid    int
+	Groups []int // 附加组
+	Home   string
+}
+
+// LookupUser 按容器内的passwd和group文件解析user，格式为user、uid、user:group或uid:gid
+// passwd中没有的uid/gid直接使用数字，与runc一样此时gid默认为0，用户不存在时home为/
+func LookupUser(user, passwdPath, groupPath string) (*ExecUser, error) {
+	userArg, groupArg := user, ""
+	if i := strings.Index(user, ":"); i >= 0 {
+		userArg, groupArg = user[:i], user[i+1:]
+	}
+	if userArg == "" {
+		return nil, fmt.Errorf("invalid user %s", user)
+	}
+
+	passwd, err := readColonFile(passwdPath)
+	if err != nil {
+		return nil, err
+	}
+	groups, err := readColonFile(groupPath)
+	if err != nil {
+		return nil, err
+	}
+
+	execUser := &ExecUser{Home: "/"}
+	userName := ""
+	found := false
+	uid, uidErr := strconv.Atoi(userArg)
+	for _, entry := range passwd {
+		// name:password:uid:gid:gecos:home:shell
+		if len(entry) < 7 {
+			continue
+		}
+		if entry[0] == userArg || (uidErr == nil && entry[2] == userArg) {
+			if execUser.Uid, err = strconv.Atoi(entry[2]); err != nil {
+				return nil, fmt.Errorf("invalid uid %s of user %s", entry[2], entry[0])
+			}
+			if execUser.Gid, err = strconv.Atoi(entry[3]); err != nil {
+				return nil, fmt.Errorf("invalid gid %s of user %s", entry[3], entry[0])
+			}
+			userName, execUser.Home, found = entry[0], entry[5], true
+			break
+		}
+	}
+	if !found {
+		if uidErr != nil {
+			return nil, fmt.Errorf("unable to find user %s: no matching entries in passwd file", userArg)
+		}
+		execUser.Uid, execUser.Gid = uid, 0
+	}
+
+	if groupArg != "" {
+		gid, gidErr := strconv.Atoi(groupArg)
+		found = false
+		for _, entry := range groups {
+			// name:password:gid:members
+			if len(entry) < 4 {
+				continue
+			}
+			if entry[0] == groupArg || (gidErr == nil && entry[2] == groupArg) {
+				if execUser.Gid, err = strconv.Atoi(entry[2]); err != nil {
+					return nil, fmt.Errorf("invalid gid %s of group %s", entry[2], entry[0])
+				}
+				found = true
+				break
+			}
+		}
+		if !found {
+			if gidErr != nil {
+				return nil, fmt.Errorf("unable to find group %s: no matching entries in group file", groupArg)
+			}
+			execUser.Gid = gid
+		}
+	}
+
+	// 附加组为group文件中成员包含该用户的组
+	if userName != "" {
+		for _, entry := range groups {
+			if len(entry) < 4 {
+				continue
+			}
+			for _, member := range strings.Split(entry[3], ",") {
+				if member != userName {
+					continue
+				}
+				if gid, err := strconv.Atoi(entry[2]); err == nil && gid != execUser.Gid {
+					execUser.Groups = append(execUser.Groups, gid)
+				}
+				break
+			}
+		}
+	}
+	return execUser, nil
+}
+
+// 读取以冒号分隔的passwd/group文件，文件不存在时返回空
+func readColonFile(filePath string) ([][]string, error) {
+	f, err := os.Open(filePath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("open %s error %v", filePath, err)
+	}
+	defer f.Close()
+
+	var entries [][]string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		entries = append(entries, strings.Split(line, ":"))
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("read %s error %v", filePath, err)
+	}
+	return entries, nil
+}
+
+// 切换到指定用户，必须先设置附加组和gid，setuid之后就没有权限了
+func setupUser(user *ExecUser) error {
+	if err := syscall.Setgroups(user.Groups); err != nil {
+		return fmt.Errorf("setgroups %v error %v", user.Groups, err)
+	}
+	if err := syscall.Setgid(user.Gid); err != nil {
+		return fmt.Errorf("setgid %d error %v", user.Gid, err)
+	}
+	if err := syscall.Setuid(user.Uid); err != nil {
+		return fmt.Errorf("setuid %d error %v", user.Uid, err)
+	}
+	return nil
+}
@@ -0,0 +1,45 @@
+package container
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestLookupUser(t *testing.T) {
+	dir := t.TempDir()
+	passwdPath := filepath.Join(dir, "passwd")
+	groupPath := filepath.Join(dir, "group")
+	passwd := "root:x:0:0:root:/root:/bin/sh\nwww:x:33:33:www:/var/www:/bin/false\n"
+	group := "root:x:0:\nwww:x:33:\nstaff:x:50:www,other\nadm:x:4:root,www\n"
+	if err := os.WriteFile(passwdPath, []byte(passwd), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(groupPath, []byte(group), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	for user, want := range map[string]ExecUser{
+		"www":       {Uid: 33, Gid: 33, Groups: []int{50, 4}, Home: "/var/www"},
+		"33":        {Uid: 33, Gid: 33, Groups: []int{50, 4}, Home: "/var/www"},
+		"www:staff": {Uid: 33, Gid: 50, Groups: []int{4}, Home: "/var/www"},
+		"1000:1001": {Uid: 1000, Gid: 1001, Home: "/"},
+		"1000":      {Uid: 1000, Gid: 0, Home: "/"},
+	} {
+		got, err := LookupUser(user, passwdPath, groupPath)
+		if err != nil {
+			t.Errorf("lookup user %s error %v", user, err)
+			continue
+		}
+		if !reflect.DeepEqual(*got, want) {
+			t.Errorf("user %s: want %+v, got %+v", user, want, *got)
+		}
+	}
+
+	for _, user := range []string{"nobody", "www:nogroup", ":33"} {
+		if _, err := LookupUser(user, passwdPath, groupPath); err == nil {
+			t.Errorf("lookup user %s should fail", user)
+		}
+	}
+}
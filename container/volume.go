@@ -2,7 +2,9 @@ package container
 
 import (
 	"fmt"
+	"github.com/moby/sys/mountinfo"
 	log "github.com/sirupsen/logrus"
+	"mydocker/image"
 	"mydocker/vars"
 	"os"
 	"os/exec"
@@ -11,12 +13,17 @@ import (
 )
 
 // create a overlay filesystem as container root workspace
-func NewWorkSpace(volume, imageName, containerName string) {
-	CreateLowerDir(imageName, containerName)
+func NewWorkSpace(volumes []string, imageName, containerName string) error {
+	lowerdir, err := CreateLowerDir(imageName, containerName)
+	if err != nil {
+		return err
+	}
 	CreateUpperDir(containerName)
 	CreateWorkDir(containerName)
-	CreateMountPoint(containerName, imageName)
-	if volume != "" {
+	if err := CreateMountPoint(containerName, lowerdir); err != nil {
+		return err
+	}
+	for _, volume := range volumes {
 		volumePaths := volumePathExtract(volume)
 		length := len(volumePaths)
 		if length == 2 && volumePaths[0] != "" && volumePaths[1] != "" {
@@ -26,24 +33,52 @@ func NewWorkSpace(volume, imageName, containerName string) {
 			log.Errorf("volume parameters input is not correct.")
 		}
 	}
+	return nil
 }
 
-func CreateLowerDir(imageName, containerName string) {
-	lowerdirPath := path.Join(fmt.Sprintf(vars.LowerDir, containerName), imageName)
-	busyboxtarPath := path.Join(vars.ImagesDir, imageName+".tar")
-	exist, err := PathExists(lowerdirPath)
+// CreateLowerDir 解析容器使用的镜像并返回overlay的lowerdir(相对于镜像存储根目录)。镜像层在镜像存储中只读共享，
+// lowerLayer目录下只记录镜像ID和lowerdir，容器重启时直接使用记录的lowerdir，
+// 不受之后重新打标签或rmi -f删除镜像的影响
+func CreateLowerDir(imageName, containerName string) (string, error) {
+	lowerdirPath := fmt.Sprintf(vars.LowerDir, containerName)
+	lowerFile := path.Join(lowerdirPath, "lower")
+	if content, err := os.ReadFile(lowerFile); err == nil {
+		return strings.TrimSpace(string(content)), nil
+	}
+	if err := os.MkdirAll(lowerdirPath, 0755); err != nil {
+		return "", fmt.Errorf("mkdir %s error %v", lowerdirPath, err)
+	}
+	imageID, err := image.Resolve(imageName)
 	if err != nil {
-		log.Infof("Fail to jude whether %s exists. %v", lowerdirPath, err)
+		return "", err
+	}
+	imageIDPath := path.Join(lowerdirPath, "image")
+	if err := os.WriteFile(imageIDPath, []byte(imageID), 0644); err != nil {
+		return "", fmt.Errorf("write %s error %v", imageIDPath, err)
 	}
-	if !exist {
-		if err := os.MkdirAll(lowerdirPath, 0755); err != nil {
-			log.Errorf("Mkdir %s error: %v", lowerdirPath, err)
-		}
 
-		if _, err := exec.Command("tar", "-xvf", busyboxtarPath, "-C", lowerdirPath).CombinedOutput(); err != nil {
-			log.Errorf("Untar %s error: %v", lowerdirPath, err)
+	img, err := image.Get(imageID)
+	if err != nil {
+		return "", err
+	}
+	// overlay的lowerdir从最上层开始排列：top:...:base，使用相对于镜像存储根目录的短链接
+	diffIDs := img.Config.RootFS.DiffIDs
+	if len(diffIDs) == 0 {
+		return "", fmt.Errorf("image %s has no layers", imageName)
+	}
+	links := make([]string, 0, len(diffIDs))
+	for i := len(diffIDs) - 1; i >= 0; i-- {
+		link, err := image.LayerLink(diffIDs[i])
+		if err != nil {
+			return "", err
 		}
+		links = append(links, link)
 	}
+	lowerdir := strings.Join(links, ":")
+	if err := os.WriteFile(lowerFile, []byte(lowerdir), 0644); err != nil {
+		return "", fmt.Errorf("write %s error %v", lowerFile, err)
+	}
+	return lowerdir, nil
 }
 
 func CreateUpperDir(containerName string) {
@@ -60,10 +95,14 @@ func CreateWorkDir(containerName string) {
 	}
 }
 
-func CreateMountPoint(containerName, imageName string) {
+func CreateMountPoint(containerName, lowerdir string) error {
 	mntPath := fmt.Sprintf(vars.MntDir, containerName)
 	if err := os.MkdirAll(mntPath, 0777); err != nil {
-		log.Errorf("mkdir %s error. %v", mntPath, err)
+		return fmt.Errorf("mkdir %s error %v", mntPath, err)
+	}
+	// 容器重启时overlay仍然挂载着，直接复用
+	if mounted, _ := mountinfo.Mounted(mntPath); mounted {
+		return nil
 	}
 
 	/*
@@ -88,13 +127,20 @@ func CreateMountPoint(containerName, imageName string) {
 		mntdir 是 overlay 文件系统挂载的目标目录，也就是我们在系统中看到的最终的虚拟文件系统。mntdir 实际上是 lowerdir 和 upperdir 的合并视图，用户可以通过 mntdir 来访问 overlay 文件系统提供的文件和目录。
 	*/
 
-	mountOptions := "lowerdir=" + path.Join(fmt.Sprintf(vars.LowerDir, containerName), imageName) + ",upperdir=" + fmt.Sprintf(vars.UpperDir, containerName) + ",workdir=" + fmt.Sprintf(vars.WorkDir, containerName)
+	mountOptions := "lowerdir=" + lowerdir + ",upperdir=" + fmt.Sprintf(vars.UpperDir, containerName) + ",workdir=" + fmt.Sprintf(vars.WorkDir, containerName)
+	// 内核只接受一个内存页以内的挂载参数
+	if len(mountOptions) >= os.Getpagesize() {
+		return fmt.Errorf("overlay mount options too long (%d bytes)", len(mountOptions))
+	}
 	cmd := exec.Command("mount", "-t", "overlay", "overlay", "-o", mountOptions, mntPath)
+	// lowerdir为相对路径，在镜像存储根目录下执行挂载
+	cmd.Dir = vars.ImageRootPath
 	cmd.Stdout = os.Stdout
 	cmd.Stderr = os.Stderr
 	if err := cmd.Run(); err != nil {
-		log.Errorf("CreateMountPoint error: %v", err)
+		return fmt.Errorf("mount overlay on %s error %v", mntPath, err)
 	}
+	return nil
 }
 
 // volume 挂载信息提取
@@ -121,6 +167,9 @@ func MountVolume(containerName string, volumePaths []string) {
 			log.Errorf("Mkdir containerVolumePath %s error: %v", containerVolumePath, err)
 		}
 	}
+	if mounted, _ := mountinfo.Mounted(containerVolumePath); mounted {
+		return
+	}
 
 	cmd := exec.Command("mount", "-t", "bind", "-o", "rbind", hostPath, containerVolumePath)
 	cmd.Stdout = os.Stdout
@@ -133,8 +182,8 @@ func MountVolume(containerName string, volumePaths []string) {
 }
 
 // delete the overlay filesystem while container exit
-func DeleteWorkSpace(containerName, volume string) {
-	if volume != "" {
+func DeleteWorkSpace(containerName string, volumes []string) {
+	for _, volume := range volumes {
 		volumePaths := volumePathExtract(volume)
 		length := len(volumePaths)
 		if length == 2 && volumePaths[0] != "" && volumePaths[1] != "" {
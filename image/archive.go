@@ -0,0 +1,324 @@
+package image
+
+import (
+	"archive/tar"
+	"fmt"
+	"golang.org/x/sys/unix"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+)
+
+/*
+	overlay与镜像层tar包中删除标记(whiteout)的表示方式不同：
+	overlay：删除的文件为设备号0/0的字符设备，目录的trusted.overlay.opaque=y表示隐藏下层目录中的全部内容
+	tar包：删除的文件为同目录下名为.wh.<文件名>的空文件，目录下的.wh..wh..opq表示隐藏下层目录中的全部内容
+*/
+
+const (
+	whiteoutPrefix = ".wh."
+	whiteoutOpaque = ".wh..wh..opq"
+	opaqueXattr    = "trusted.overlay.opaque"
+)
+
+// TarDiff 将overlay的upper目录打包为镜像层tar包，删除标记转换为tar包中的表示方式
+func TarDiff(dir string, w io.Writer) error {
+	tw := tar.NewWriter(w)
+	// 硬链接的文件只打包一次，其余作为链接
+	inodes := map[uint64]string{}
+
+	err := filepath.Walk(dir, func(filePath string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		name, err := filepath.Rel(dir, filePath)
+		if err != nil || name == "." {
+			return err
+		}
+		stat, ok := info.Sys().(*syscall.Stat_t)
+		if !ok {
+			return fmt.Errorf("stat %s error", filePath)
+		}
+
+		// 删除的文件
+		if info.Mode()&os.ModeCharDevice != 0 && stat.Rdev == 0 {
+			return tw.WriteHeader(&tar.Header{
+				Name:     filepath.Join(filepath.Dir(name), whiteoutPrefix+info.Name()),
+				Typeflag: tar.TypeReg,
+				Mode:     0600,
+				ModTime:  info.ModTime(),
+				Format:   tar.FormatPAX,
+			})
+		}
+
+		// socket只在创建它的进程运行时有意义，与docker一样不打包
+		if info.Mode()&os.ModeSocket != 0 {
+			return nil
+		}
+
+		link := ""
+		if info.Mode()&os.ModeSymlink != 0 {
+			if link, err = os.Readlink(filePath); err != nil {
+				return fmt.Errorf("read link %s error %v", filePath, err)
+			}
+		}
+		hdr, err := tar.FileInfoHeader(info, link)
+		if err != nil {
+			return fmt.Errorf("tar header of %s error %v", filePath, err)
+		}
+		hdr.Name = name
+		if info.IsDir() {
+			hdr.Name += "/"
+		}
+		// 只保留数字的uid/gid，用户名在容器中没有意义
+		hdr.Uid, hdr.Gid = int(stat.Uid), int(stat.Gid)
+		hdr.Uname, hdr.Gname = "", ""
+		hdr.AccessTime, hdr.ChangeTime = time.Time{}, time.Time{}
+		hdr.Format = tar.FormatPAX
+		if info.Mode().IsRegular() && stat.Nlink > 1 {
+			if first, ok := inodes[stat.Ino]; ok {
+				hdr.Typeflag, hdr.Linkname, hdr.Size = tar.TypeLink, first, 0
+			} else {
+				inodes[stat.Ino] = name
+			}
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			return fmt.Errorf("write tar header of %s error %v", name, err)
+		}
+
+		if hdr.Typeflag == tar.TypeReg && hdr.Size > 0 {
+			f, err := os.Open(filePath)
+			if err != nil {
+				return fmt.Errorf("open %s error %v", filePath, err)
+			}
+			_, err = io.Copy(tw, f)
+			f.Close()
+			if err != nil {
+				return fmt.Errorf("write %s to tar error %v", name, err)
+			}
+		}
+
+		// 被重新创建的目录，隐藏下层目录中的内容
+		if info.IsDir() && isOpaque(filePath) {
+			return tw.WriteHeader(&tar.Header{
+				Name:     filepath.Join(name, whiteoutOpaque),
+				Typeflag: tar.TypeReg,
+				Mode:     0600,
+				ModTime:  info.ModTime(),
+				Format:   tar.FormatPAX,
+			})
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	return tw.Close()
+}
+
+func isOpaque(dir string) bool {
+	buf := make([]byte, 1)
+	n, err := unix.Lgetxattr(dir, opaqueXattr, buf)
+	return err == nil && n == 1 && buf[0] == 'y'
+}
+
+// 将解压后的镜像层中tar包形式的删除标记转换为overlay的表示方式
+func convertWhiteouts(dir string) error {
+	var whiteouts []string
+	err := filepath.Walk(dir, func(filePath string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if strings.HasPrefix(info.Name(), whiteoutPrefix) {
+			whiteouts = append(whiteouts, filePath)
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("walk %s error %v", dir, err)
+	}
+
+	for _, whiteout := range whiteouts {
+		parent, name := filepath.Split(whiteout)
+		if err := os.Remove(whiteout); err != nil {
+			return fmt.Errorf("remove %s error %v", whiteout, err)
+		}
+		if name == whiteoutOpaque {
+			if err := unix.Lsetxattr(parent, opaqueXattr, []byte("y"), 0); err != nil {
+				return fmt.Errorf("set opaque on %s error %v", parent, err)
+			}
+			continue
+		}
+		target := filepath.Join(parent, strings.TrimPrefix(name, whiteoutPrefix))
+		if err := unix.Mknod(target, unix.S_IFCHR, 0); err != nil {
+			return fmt.Errorf("create whiteout %s error %v", target, err)
+		}
+	}
+	return nil
+}
+
+// 将tar包解压到dir，代替外部的tar命令。条目的路径和硬链接都不能指向dir之外，
+// 路径中的符号链接以dir为根解析，防止构造的tar包写到主机上的任意位置
+func untar(r io.Reader, dir string) error {
+	tr := tar.NewReader(r)
+	// 目录的修改时间在其中的内容解压完后再设置
+	var dirs []*tar.Header
+	var dirPaths []string
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("read tar error %v", err)
+		}
+		target, err := resolvePath(dir, hdr.Name)
+		if err != nil {
+			return err
+		}
+		if target == filepath.Clean(dir) {
+			continue
+		}
+		if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+			return fmt.Errorf("mkdir %s error %v", filepath.Dir(target), err)
+		}
+		// 已存在的同名条目(目录除外)先删除，不能通过其中的符号链接写入
+		if info, err := os.Lstat(target); err == nil && !(info.IsDir() && hdr.Typeflag == tar.TypeDir) {
+			if err := os.RemoveAll(target); err != nil {
+				return fmt.Errorf("remove %s error %v", target, err)
+			}
+		}
+
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			if err := os.Mkdir(target, 0755); err != nil && !os.IsExist(err) {
+				return fmt.Errorf("mkdir %s error %v", target, err)
+			}
+			dirs, dirPaths = append(dirs, hdr), append(dirPaths, target)
+		case tar.TypeReg:
+			f, err := os.OpenFile(target, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+			if err != nil {
+				return fmt.Errorf("create %s error %v", target, err)
+			}
+			_, err = io.Copy(f, tr)
+			f.Close()
+			if err != nil {
+				return fmt.Errorf("write %s error %v", target, err)
+			}
+		case tar.TypeSymlink:
+			// 符号链接本身可以指向任意位置，只是解压时不会穿过它写入
+			if err := os.Symlink(hdr.Linkname, target); err != nil {
+				return fmt.Errorf("create symlink %s error %v", target, err)
+			}
+		case tar.TypeLink:
+			source, err := resolvePath(dir, hdr.Linkname)
+			if err != nil {
+				return err
+			}
+			if err := os.Link(source, target); err != nil {
+				return fmt.Errorf("create hard link %s error %v", target, err)
+			}
+			continue
+		case tar.TypeChar, tar.TypeBlock, tar.TypeFifo:
+			mode := uint32(hdr.Mode & 07777)
+			switch hdr.Typeflag {
+			case tar.TypeChar:
+				mode |= unix.S_IFCHR
+			case tar.TypeBlock:
+				mode |= unix.S_IFBLK
+			default:
+				mode |= unix.S_IFIFO
+			}
+			if err := unix.Mknod(target, mode, int(unix.Mkdev(uint32(hdr.Devmajor), uint32(hdr.Devminor)))); err != nil {
+				return fmt.Errorf("mknod %s error %v", target, err)
+			}
+		default:
+			return fmt.Errorf("unsupported tar entry %s type %c", hdr.Name, hdr.Typeflag)
+		}
+		if err := setAttrs(target, hdr); err != nil {
+			return err
+		}
+	}
+	for i, hdr := range dirs {
+		if err := setTimes(dirPaths[i], hdr); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// 按tar条目设置数字的uid/gid、权限和修改时间
+func setAttrs(target string, hdr *tar.Header) error {
+	if err := os.Lchown(target, hdr.Uid, hdr.Gid); err != nil {
+		return fmt.Errorf("chown %s error %v", target, err)
+	}
+	if hdr.Typeflag != tar.TypeSymlink {
+		if err := os.Chmod(target, hdr.FileInfo().Mode()); err != nil {
+			return fmt.Errorf("chmod %s error %v", target, err)
+		}
+	}
+	return setTimes(target, hdr)
+}
+
+func setTimes(target string, hdr *tar.Header) error {
+	atime := hdr.AccessTime
+	if atime.IsZero() {
+		atime = hdr.ModTime
+	}
+	ts := []unix.Timespec{unix.NsecToTimespec(atime.UnixNano()), unix.NsecToTimespec(hdr.ModTime.UnixNano())}
+	if err := unix.UtimesNanoAt(unix.AT_FDCWD, target, ts, unix.AT_SYMLINK_NOFOLLOW); err != nil {
+		return fmt.Errorf("set times of %s error %v", target, err)
+	}
+	return nil
+}
+
+// 将tar包中的路径解析为dir下的路径：最后一级之前的符号链接按dir为根解析(与容器中看到的一致)，
+// 解析结果在dir之外时返回错误
+func resolvePath(dir, name string) (string, error) {
+	target, err := safeJoin(dir, name)
+	if err != nil {
+		return "", err
+	}
+	root := filepath.Clean(dir)
+	if target == root {
+		return root, nil
+	}
+	rel, err := filepath.Rel(root, filepath.Dir(target))
+	if err != nil {
+		return "", fmt.Errorf("invalid path %s in archive", name)
+	}
+
+	parent, links := root, 0
+	pending := strings.Split(rel, "/")
+	for len(pending) > 0 {
+		elem := pending[0]
+		pending = pending[1:]
+		if elem == "" || elem == "." {
+			continue
+		}
+		next := filepath.Join(parent, elem)
+		if next != root && !strings.HasPrefix(next, root+"/") {
+			return "", fmt.Errorf("path %s in archive resolves outside the layer", name)
+		}
+		info, err := os.Lstat(next)
+		if err != nil || info.Mode()&os.ModeSymlink == 0 {
+			parent = next
+			continue
+		}
+		if links++; links > 255 {
+			return "", fmt.Errorf("too many symlinks in path %s", name)
+		}
+		link, err := os.Readlink(next)
+		if err != nil {
+			return "", fmt.Errorf("read link %s error %v", next, err)
+		}
+		if filepath.IsAbs(link) {
+			parent = root
+		}
+		pending = append(strings.Split(link, "/"), pending...)
+	}
+	return filepath.Join(parent, filepath.Base(target)), nil
+}
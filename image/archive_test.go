@@ -0,0 +1,150 @@
+package image
+
+import (
+	"archive/tar"
+	"bytes"
+	"golang.org/x/sys/unix"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+)
+
+func TestTarDiffWhiteouts(t *testing.T) {
+	if os.Getuid() != 0 {
+		t.Skip("creating whiteouts requires root")
+	}
+	upper := t.TempDir()
+	os.MkdirAll(filepath.Join(upper, "etc"), 0755)
+	os.MkdirAll(filepath.Join(upper, "data"), 0755)
+	os.WriteFile(filepath.Join(upper, "data", "b"), []byte("b"), 0644)
+	if err := unix.Mknod(filepath.Join(upper, "etc", "group"), unix.S_IFCHR, 0); err != nil {
+		t.Fatal(err)
+	}
+	if err := unix.Setxattr(filepath.Join(upper, "data"), opaqueXattr, []byte("y"), 0); err != nil {
+		t.Skipf("trusted xattr not supported: %v", err)
+	}
+
+	buf := &bytes.Buffer{}
+	if err := TarDiff(upper, buf); err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	tr := tar.NewReader(bytes.NewReader(buf.Bytes()))
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		names = append(names, hdr.Name)
+	}
+	sort.Strings(names)
+	want := []string{"data/", "data/.wh..wh..opq", "data/b", "etc/", "etc/.wh.group"}
+	if len(names) != len(want) {
+		t.Fatalf("want %v, got %v", want, names)
+	}
+	for i := range want {
+		if names[i] != want[i] {
+			t.Fatalf("want %v, got %v", want, names)
+		}
+	}
+
+	// 导入后还原为overlay的删除标记
+	setupStore(t)
+	layer, err := ImportLayer(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	diff := LayerPath(layer.DiffID)
+	var stat unix.Stat_t
+	if err := unix.Lstat(filepath.Join(diff, "etc", "group"), &stat); err != nil || stat.Mode&unix.S_IFMT != unix.S_IFCHR || stat.Rdev != 0 {
+		t.Errorf("etc/group should be a whiteout, %v", err)
+	}
+	if !isOpaque(filepath.Join(diff, "data")) {
+		t.Errorf("data should be opaque")
+	}
+	if _, err := os.Stat(filepath.Join(diff, "data", whiteoutOpaque)); !os.IsNotExist(err) {
+		t.Errorf("opaque marker should be removed")
+	}
+}
+
+func TestTarDiffSkipsSockets(t *testing.T) {
+	upper := t.TempDir()
+	os.WriteFile(filepath.Join(upper, "a"), []byte("a"), 0644)
+	l, err := net.Listen("unix", filepath.Join(upper, "app.sock"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	buf := &bytes.Buffer{}
+	if err := TarDiff(upper, buf); err != nil {
+		t.Fatal(err)
+	}
+	tr := tar.NewReader(buf)
+	var names []string
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		names = append(names, hdr.Name)
+	}
+	if len(names) != 1 || names[0] != "a" {
+		t.Errorf("want [a], got %v", names)
+	}
+}
+
+func TestUntarConfinedToDir(t *testing.T) {
+	outside := t.TempDir()
+	build := func(hdrs ...*tar.Header) *bytes.Buffer {
+		buf := &bytes.Buffer{}
+		tw := tar.NewWriter(buf)
+		for _, hdr := range hdrs {
+			if hdr.Typeflag == tar.TypeReg {
+				hdr.Size = int64(len("pwned"))
+			}
+			hdr.Mode = 0644
+			if err := tw.WriteHeader(hdr); err != nil {
+				t.Fatal(err)
+			}
+			if hdr.Typeflag == tar.TypeReg {
+				tw.Write([]byte("pwned"))
+			}
+		}
+		tw.Close()
+		return buf
+	}
+
+	dir := t.TempDir()
+	rel, _ := filepath.Rel(dir, outside)
+	for name, archive := range map[string]*bytes.Buffer{
+		"dotdot":   build(&tar.Header{Name: rel + "/a", Typeflag: tar.TypeReg}),
+		"symlink":  build(&tar.Header{Name: "evil", Typeflag: tar.TypeSymlink, Linkname: rel}, &tar.Header{Name: "evil/a", Typeflag: tar.TypeReg}),
+		"hardlink": build(&tar.Header{Name: "evil", Typeflag: tar.TypeLink, Linkname: rel + "/a"}),
+	} {
+		if err := untar(archive, t.TempDir()); err == nil {
+			t.Errorf("%s: escaping entry should fail", name)
+		}
+	}
+
+	// 绝对路径的符号链接以解压目录为根解析，写入的仍然是解压目录中的文件
+	archive := build(&tar.Header{Name: "abs", Typeflag: tar.TypeSymlink, Linkname: outside}, &tar.Header{Name: "abs/a", Typeflag: tar.TypeReg})
+	if err := untar(archive, dir); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(outside, "a")); !os.IsNotExist(err) {
+		t.Errorf("file written outside the dir")
+	}
+	if content, err := os.ReadFile(filepath.Join(dir, outside, "a")); err != nil || string(content) != "pwned" {
+		t.Errorf("want file under the dir, got %q %v", content, err)
+	}
+}
@@ -0,0 +1,327 @@
+package image
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"mydocker/vars"
+	"os"
+	"path"
+	"runtime"
+	"strings"
+	"time"
+)
+
+/*
+	镜像存储，目录结构(RootPath/image下)：
+	layers/sha256/<hex>/diff        镜像层解压后的内容，按层tar包(未压缩)的sha256存放，相同的层只存一份，所有容器只读共享
+	layers/sha256/<hex>/layer.json  镜像层的元数据
+	layers/sha256/<hex>/link        镜像层的短ID，l/<短ID>为指向diff目录的符号链接
+	imagedb/sha256/<hex>/config.json   镜像的config，镜像ID为config内容的sha256
+	imagedb/sha256/<hex>/manifest.json 镜像的manifest，记录config和各层
+	repositories.json               name:tag到镜像ID的索引
+*/
+
+const (
+	MediaTypeManifest = "application/vnd.oci.image.manifest.v1+json"
+	MediaTypeConfig   = "application/vnd.oci.image.config.v1+json"
+	MediaTypeLayer    = "application/vnd.oci.image.layer.v1.tar"
+)
+
+// Config 镜像的config，格式与OCI image-spec的config一致
+type Config struct {
+	Created      string          `json:"created,omitempty"`
+	Author       string          `json:"author,omitempty"`
+	Architecture string          `json:"architecture"`
+	OS           string          `json:"os"`
+	Config       ContainerConfig `json:"config,omitempty"`
+	RootFS       RootFS          `json:"rootfs"`
+	History      []History       `json:"history,omitempty"`
+}
+
+// ContainerConfig 使用镜像创建容器时的默认配置
+type ContainerConfig struct {
+	User       string   `json:"User,omitempty"`
+	Env        []string `json:"Env,omitempty"`
+	Entrypoint []string `json:"Entrypoint,omitempty"`
+	Cmd        []string `json:"Cmd,omitempty"`
+	WorkingDir string   `json:"WorkingDir,omitempty"`
+}
+
+// RootFS 镜像的各层，DiffIDs从最底层开始排列
+type RootFS struct {
+	Type    string   `json:"type"`
+	DiffIDs []string `json:"diff_ids"`
+}
+
+type History struct {
+	Created    string `json:"created,omitempty"`
+	CreatedBy  string `json:"created_by,omitempty"`
+	Author     string `json:"author,omitempty"`
+	Comment    string `json:"comment,omitempty"`
+	EmptyLayer bool   `json:"empty_layer,omitempty"`
+}
+
+// Manifest 镜像的manifest
+type Manifest struct {
+	SchemaVersion int          `json:"schemaVersion"`
+	MediaType     string       `json:"mediaType"`
+	Config        Descriptor   `json:"config"`
+	Layers        []Descriptor `json:"layers"`
+}
+
+type Descriptor struct {
+	MediaType   string            `json:"mediaType"`
+	Digest      string            `json:"digest"`
+	Size        int64             `json:"size"`
+	Annotations map[string]string `json:"annotations,omitempty"`
+	Platform    *Platform         `json:"platform,omitempty"`
+}
+
+type Platform struct {
+	Architecture string `json:"architecture"`
+	OS           string `json:"os"`
+}
+
+// Image 镜像存储中的一个镜像
+type Image struct {
+	ID       string // sha256:<hex>
+	Config   *Config
+	Manifest *Manifest
+}
+
+// NewConfig 创建一个没有层的镜像config，创建时间为当前时间
+func NewConfig() *Config {
+	return &Config{
+		Created:      time.Now().UTC().Format(time.RFC3339Nano),
+		Architecture: runtime.GOARCH,
+		OS:           "linux",
+		RootFS:       RootFS{Type: "layers"},
+	}
+}
+
+// Digest 计算内容的sha256摘要，格式为sha256:<hex>
+func Digest(content []byte) string {
+	sum := sha256.Sum256(content)
+	return "sha256:" + hex.EncodeToString(sum[:])
+}
+
+// 去掉摘要的算法前缀
+func digestHex(digest string) string {
+	return strings.TrimPrefix(digest, "sha256:")
+}
+
+// ShortID 镜像ID的前12位，用于显示
+func ShortID(id string) string {
+	if h := digestHex(id); len(h) > 12 {
+		return h[:12]
+	}
+	return digestHex(id)
+}
+
+func imageDir(id string) string {
+	return path.Join(vars.ImageDBDir, digestHex(id))
+}
+
+// Create 将config及其各层写入镜像存储，返回镜像ID。各层必须已经导入
+func Create(config *Config) (string, error) {
+	content, err := json.Marshal(config)
+	if err != nil {
+		return "", fmt.Errorf("marshal image config error %v", err)
+	}
+	return createImage(content, config)
+}
+
+// 按config的原始内容保存镜像，导入的镜像保持原来的镜像ID
+func createImage(content []byte, config *Config) (string, error) {
+	id := Digest(content)
+
+	manifest := &Manifest{
+		SchemaVersion: 2,
+		MediaType:     MediaTypeManifest,
+		Config:        Descriptor{MediaType: MediaTypeConfig, Digest: id, Size: int64(len(content))},
+		Layers:        []Descriptor{},
+	}
+	for _, diffID := range config.RootFS.DiffIDs {
+		layer, err := GetLayer(diffID)
+		if err != nil {
+			return "", err
+		}
+		manifest.Layers = append(manifest.Layers, Descriptor{MediaType: MediaTypeLayer, Digest: diffID, Size: layer.Size})
+	}
+	manifestContent, err := json.Marshal(manifest)
+	if err != nil {
+		return "", fmt.Errorf("marshal image manifest error %v", err)
+	}
+
+	dir := imageDir(id)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", fmt.Errorf("mkdir %s error %v", dir, err)
+	}
+	if err := writeFileAtomic(path.Join(dir, "manifest.json"), manifestContent); err != nil {
+		return "", err
+	}
+	// config最后写入，存在config.json即表示镜像完整
+	if err := writeFileAtomic(path.Join(dir, "config.json"), content); err != nil {
+		return "", err
+	}
+	return id, nil
+}
+
+// Get 根据镜像ID读取镜像
+func Get(id string) (*Image, error) {
+	dir := imageDir(id)
+	img := &Image{ID: "sha256:" + digestHex(id), Config: &Config{}, Manifest: &Manifest{}}
+	content, err := os.ReadFile(path.Join(dir, "config.json"))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, fmt.Errorf("no such image %s", id)
+		}
+		return nil, fmt.Errorf("read image %s config error %v", id, err)
+	}
+	if err := json.Unmarshal(content, img.Config); err != nil {
+		return nil, fmt.Errorf("parse image %s config error %v", id, err)
+	}
+	if content, err = os.ReadFile(path.Join(dir, "manifest.json")); err != nil {
+		return nil, fmt.Errorf("read image %s manifest error %v", id, err)
+	}
+	if err := json.Unmarshal(content, img.Manifest); err != nil {
+		return nil, fmt.Errorf("parse image %s manifest error %v", id, err)
+	}
+	return img, nil
+}
+
+// List 列出镜像存储中的全部镜像ID
+func List() ([]string, error) {
+	entries, err := os.ReadDir(vars.ImageDBDir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("read %s error %v", vars.ImageDBDir, err)
+	}
+	var ids []string
+	for _, entry := range entries {
+		if _, err := os.Stat(path.Join(vars.ImageDBDir, entry.Name(), "config.json")); err == nil {
+			ids = append(ids, "sha256:"+entry.Name())
+		}
+	}
+	return ids, nil
+}
+
+// Resolve 将镜像名name[:tag]、镜像ID或ID前缀解析为镜像ID
+// 镜像存储中没有而ImagesDir下有同名的tar包时，先将tar包导入为单层镜像
+func Resolve(ref string) (string, error) {
+	id, err := Lookup(ref)
+	if err != nil || id != "" {
+		return id, err
+	}
+	repo, tag := ParseReference(ref)
+	legacyTar := path.Join(vars.ImagesDir, repo+".tar")
+	if tag == DefaultTag {
+		if _, err := os.Stat(legacyTar); err == nil {
+			return importLegacyImage(legacyTar, NormalizeName(ref))
+		}
+	}
+	return "", fmt.Errorf("no such image %s", ref)
+}
+
+// Lookup 在镜像存储中查找镜像名、镜像ID或ID前缀对应的镜像，没有找到时返回空
+func Lookup(ref string) (string, error) {
+	if ref == "" {
+		return "", fmt.Errorf("missing image name")
+	}
+	repositories, err := loadRepositories()
+	if err != nil {
+		return "", err
+	}
+	if id, ok := repositories[NormalizeName(ref)]; ok {
+		return id, nil
+	}
+	return lookupID(ref)
+}
+
+// Delete 删除镜像的config、manifest及指向它的全部标签，镜像层由RemoveUnusedLayers清理
+func Delete(id string) error {
+	repositories, err := loadRepositories()
+	if err != nil {
+		return err
+	}
+	for name, imageID := range repositories {
+		if imageID == id {
+			delete(repositories, name)
+		}
+	}
+	if err := saveRepositories(repositories); err != nil {
+		return err
+	}
+	if err := os.RemoveAll(imageDir(id)); err != nil {
+		return fmt.Errorf("remove image %s error %v", id, err)
+	}
+	return nil
+}
+
+// 按镜像ID或ID前缀(至少4位)查找镜像，没有找到时返回空
+func lookupID(ref string) (string, error) {
+	prefix := digestHex(ref)
+	if len(prefix) < 4 || strings.Trim(prefix, "0123456789abcdef") != "" {
+		return "", nil
+	}
+	ids, err := List()
+	if err != nil {
+		return "", err
+	}
+	var found string
+	for _, id := range ids {
+		if strings.HasPrefix(digestHex(id), prefix) {
+			if found != "" {
+				return "", fmt.Errorf("image id prefix %s is ambiguous", ref)
+			}
+			found = id
+		}
+	}
+	return found, nil
+}
+
+// 将旧版本ImagesDir下的镜像tar包导入为单层镜像并打上name标签
+func importLegacyImage(tarPath, name string) (string, error) {
+	log.Infof("import image %s from %s", name, tarPath)
+	f, err := os.Open(tarPath)
+	if err != nil {
+		return "", fmt.Errorf("open %s error %v", tarPath, err)
+	}
+	defer f.Close()
+	layer, err := ImportLayer(f)
+	if err != nil {
+		return "", err
+	}
+
+	config := NewConfig()
+	if info, err := f.Stat(); err == nil {
+		config.Created = info.ModTime().UTC().Format(time.RFC3339Nano)
+	}
+	config.RootFS.DiffIDs = []string{layer.DiffID}
+	config.History = []History{{Created: config.Created, CreatedBy: "import " + tarPath}}
+	id, err := Create(config)
+	if err != nil {
+		return "", err
+	}
+	if err := Tag(name, id); err != nil {
+		return "", err
+	}
+	return id, nil
+}
+
+// 先写入临时文件再重命名，避免读到写了一半的文件
+func writeFileAtomic(filePath string, content []byte) error {
+	tmp := filePath + ".tmp"
+	if err := os.WriteFile(tmp, content, 0644); err != nil {
+		return fmt.Errorf("write %s error %v", tmp, err)
+	}
+	if err := os.Rename(tmp, filePath); err != nil {
+		return fmt.Errorf("rename %s error %v", tmp, err)
+	}
+	return nil
+}
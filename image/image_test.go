@@ -0,0 +1,182 @@
+package image
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"mydocker/vars"
+	"os"
+	"path"
+	"testing"
+)
+
+// 将镜像存储指向临时目录
+func setupStore(t *testing.T) {
+	root := t.TempDir()
+	oldImagesDir, oldImageRoot, oldLayers, oldLinks, oldImageDB, oldRepositories := vars.ImagesDir, vars.ImageRootPath, vars.LayersDir, vars.LayerLinkDir, vars.ImageDBDir, vars.RepositoriesFile
+	vars.ImagesDir = path.Join(root, "images")
+	vars.ImageRootPath = path.Join(root, "image")
+	vars.LayersDir = path.Join(vars.ImageRootPath, "layers/sha256")
+	vars.LayerLinkDir = path.Join(vars.ImageRootPath, "l")
+	vars.ImageDBDir = path.Join(vars.ImageRootPath, "imagedb/sha256")
+	vars.RepositoriesFile = path.Join(vars.ImageRootPath, "repositories.json")
+	t.Cleanup(func() {
+		vars.ImagesDir, vars.ImageRootPath, vars.LayersDir, vars.LayerLinkDir, vars.ImageDBDir, vars.RepositoriesFile = oldImagesDir, oldImageRoot, oldLayers, oldLinks, oldImageDB, oldRepositories
+	})
+}
+
+// 生成只包含一个文件的tar包
+func tarFile(t *testing.T, name, content string) []byte {
+	buf := &bytes.Buffer{}
+	tw := tar.NewWriter(buf)
+	if err := tw.WriteHeader(&tar.Header{Name: name, Mode: 0644, Size: int64(len(content)), Typeflag: tar.TypeReg}); err != nil {
+		t.Fatal(err)
+	}
+	tw.Write([]byte(content))
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestParseReference(t *testing.T) {
+	for ref, want := range map[string][2]string{
+		"busybox":                    {"busybox", "latest"},
+		"busybox:1.36":               {"busybox", "1.36"},
+		"localhost:5000/busybox":     {"localhost:5000/busybox", "latest"},
+		"localhost:5000/busybox:1.0": {"localhost:5000/busybox", "1.0"},
+	} {
+		if repo, tag := ParseReference(ref); repo != want[0] || tag != want[1] {
+			t.Errorf("%s: want %v, got %s %s", ref, want, repo, tag)
+		}
+	}
+}
+
+func TestImportLayerAndResolve(t *testing.T) {
+	setupStore(t)
+	layerTar := tarFile(t, "hello", "world")
+
+	// 相同内容的层无论是否压缩都只存一份
+	layer, err := ImportLayer(bytes.NewReader(layerTar))
+	if err != nil {
+		t.Fatal(err)
+	}
+	gzBuf := &bytes.Buffer{}
+	gz := gzip.NewWriter(gzBuf)
+	gz.Write(layerTar)
+	gz.Close()
+	gzLayer, err := ImportLayer(gzBuf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if layer.DiffID != Digest(layerTar) || gzLayer.DiffID != layer.DiffID || layer.Size != int64(len(layerTar)) {
+		t.Errorf("unexpected layers %+v %+v", layer, gzLayer)
+	}
+	if content, err := os.ReadFile(path.Join(LayerPath(layer.DiffID), "hello")); err != nil || string(content) != "world" {
+		t.Errorf("unexpected layer content %q %v", content, err)
+	}
+
+	// 短链接创建后保持不变，并指向层的diff目录
+	link, err := LayerLink(layer.DiffID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again, err := LayerLink(layer.DiffID); err != nil || again != link || len(link) != len("l/")+26 {
+		t.Errorf("unexpected layer link %s %s %v", link, again, err)
+	}
+	if content, err := os.ReadFile(path.Join(vars.ImageRootPath, link, "hello")); err != nil || string(content) != "world" {
+		t.Errorf("unexpected content through link %q %v", content, err)
+	}
+
+	config := NewConfig()
+	config.RootFS.DiffIDs = []string{layer.DiffID}
+	id, err := Create(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := Tag("hello:v1", id); err != nil {
+		t.Fatal(err)
+	}
+	for _, ref := range []string{"hello:v1", id, ShortID(id)} {
+		if got, err := Resolve(ref); err != nil || got != id {
+			t.Errorf("resolve %s: want %s, got %s %v", ref, id, got, err)
+		}
+	}
+	if _, err := Resolve("hello"); err == nil {
+		t.Errorf("hello:latest should not exist")
+	}
+	img, err := Get(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(img.Manifest.Layers) != 1 || img.Manifest.Layers[0].Digest != layer.DiffID {
+		t.Errorf("unexpected manifest %+v", img.Manifest)
+	}
+}
+
+func TestResolveLegacyImage(t *testing.T) {
+	setupStore(t)
+	if err := os.MkdirAll(vars.ImagesDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path.Join(vars.ImagesDir, "legacy.tar"), tarFile(t, "bin/sh", "#!"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	id, err := Resolve("legacy")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 导入后按标签解析
+	if got, err := Resolve("legacy:latest"); err != nil || got != id {
+		t.Errorf("want %s, got %s %v", id, got, err)
+	}
+}
+
+func TestDeleteImage(t *testing.T) {
+	setupStore(t)
+	base, err := ImportLayer(bytes.NewReader(tarFile(t, "base", "base")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	top, err := ImportLayer(bytes.NewReader(tarFile(t, "top", "top")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	config := NewConfig()
+	config.RootFS.DiffIDs = []string{base.DiffID}
+	baseID, err := Create(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	config.RootFS.DiffIDs = []string{base.DiffID, top.DiffID}
+	topID, err := Create(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	Tag("top", topID)
+	Tag("top:v1", topID)
+
+	if id, err := Untag("top:v1"); err != nil || id != topID {
+		t.Errorf("untag: want %s, got %s %v", topID, id, err)
+	}
+	if tags, _ := Tags(topID); len(tags) != 1 || tags[0] != "top:latest" {
+		t.Errorf("unexpected tags %v", tags)
+	}
+
+	// 删除上层镜像后，只有被占用的层和基础镜像的层保留
+	if err := Delete(topID); err != nil {
+		t.Fatal(err)
+	}
+	if removed, err := RemoveUnusedLayers(map[string]bool{top.DiffID: true}); err != nil || len(removed) != 0 {
+		t.Errorf("layer in use should be kept, removed %v %v", removed, err)
+	}
+	if removed, err := RemoveUnusedLayers(nil); err != nil || len(removed) != 1 || removed[0] != top.DiffID {
+		t.Errorf("want %s removed, got %v %v", top.DiffID, removed, err)
+	}
+	if _, err := GetLayer(base.DiffID); err != nil {
+		t.Errorf("layer of %s should be kept: %v", baseID, err)
+	}
+	if id, _ := Lookup("top"); id != "" {
+		t.Errorf("top should be deleted, got %s", id)
+	}
+}
@@ -0,0 +1,238 @@
+package image
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base32"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"github.com/klauspost/compress/zstd"
+	"io"
+	"mydocker/vars"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// Layer 镜像层的元数据，保存在layer.json中
+type Layer struct {
+	DiffID string `json:"diffId"` // 未压缩的层tar包的sha256
+	Size   int64  `json:"size"`   // 未压缩的层tar包的大小
+}
+
+func layerDir(diffID string) string {
+	return path.Join(vars.LayersDir, digestHex(diffID))
+}
+
+// LayerPath 镜像层解压后的目录
+func LayerPath(diffID string) string {
+	return path.Join(layerDir(diffID), "diff")
+}
+
+// LayerLink 返回镜像层相对于镜像存储根目录的短路径l/<短ID>，没有时创建。
+// 与overlay2一样，多层镜像的lowerdir使用短路径，避免挂载参数超过一个内存页的限制
+func LayerLink(diffID string) (string, error) {
+	if _, err := GetLayer(diffID); err != nil {
+		return "", err
+	}
+	linkFile := path.Join(layerDir(diffID), "link")
+	if content, err := os.ReadFile(linkFile); err == nil {
+		id := strings.TrimSpace(string(content))
+		if _, err := os.Lstat(path.Join(vars.LayerLinkDir, id)); err == nil {
+			return path.Join("l", id), nil
+		}
+	}
+
+	if err := os.MkdirAll(vars.LayerLinkDir, 0755); err != nil {
+		return "", fmt.Errorf("mkdir %s error %v", vars.LayerLinkDir, err)
+	}
+	randBytes := make([]byte, 16)
+	if _, err := rand.Read(randBytes); err != nil {
+		return "", fmt.Errorf("generate layer link id error %v", err)
+	}
+	id := base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(randBytes)
+	// 使用相对路径的链接，镜像存储整体移动后仍然有效
+	target, err := filepath.Rel(vars.LayerLinkDir, LayerPath(diffID))
+	if err != nil {
+		return "", fmt.Errorf("layer link target error %v", err)
+	}
+	if err := os.Symlink(target, path.Join(vars.LayerLinkDir, id)); err != nil {
+		return "", fmt.Errorf("create layer link error %v", err)
+	}
+	if err := writeFileAtomic(linkFile, []byte(id)); err != nil {
+		return "", err
+	}
+	return path.Join("l", id), nil
+}
+
+// LinkLayer 根据短路径l/<短ID>找到对应的镜像层
+func LinkLayer(link string) (string, error) {
+	target, err := os.Readlink(path.Join(vars.ImageRootPath, link))
+	if err != nil {
+		return "", fmt.Errorf("read layer link %s error %v", link, err)
+	}
+	// 链接指向layers/sha256/<hex>/diff
+	return "sha256:" + path.Base(path.Dir(target)), nil
+}
+
+// RemoveUnusedLayers 删除没有被任何镜像使用、也不在inUse中的镜像层，返回删除的层
+func RemoveUnusedLayers(inUse map[string]bool) ([]string, error) {
+	ids, err := List()
+	if err != nil {
+		return nil, err
+	}
+	used := map[string]bool{}
+	for diffID := range inUse {
+		used[diffID] = true
+	}
+	for _, id := range ids {
+		img, err := Get(id)
+		if err != nil {
+			return nil, err
+		}
+		for _, diffID := range img.Config.RootFS.DiffIDs {
+			used[diffID] = true
+		}
+	}
+
+	entries, err := os.ReadDir(vars.LayersDir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("read %s error %v", vars.LayersDir, err)
+	}
+	var removed []string
+	for _, entry := range entries {
+		diffID := "sha256:" + entry.Name()
+		// 跳过正在导入的临时目录
+		if strings.HasPrefix(entry.Name(), "tmp-") || used[diffID] {
+			continue
+		}
+		if link, err := os.ReadFile(path.Join(layerDir(diffID), "link")); err == nil {
+			os.Remove(path.Join(vars.LayerLinkDir, strings.TrimSpace(string(link))))
+		}
+		if err := os.RemoveAll(layerDir(diffID)); err != nil {
+			return removed, fmt.Errorf("remove layer %s error %v", diffID, err)
+		}
+		removed = append(removed, diffID)
+	}
+	return removed, nil
+}
+
+// GetLayer 读取已导入的镜像层
+func GetLayer(diffID string) (*Layer, error) {
+	content, err := os.ReadFile(path.Join(layerDir(diffID), "layer.json"))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, fmt.Errorf("no such layer %s", diffID)
+		}
+		return nil, fmt.Errorf("read layer %s error %v", diffID, err)
+	}
+	layer := &Layer{}
+	if err := json.Unmarshal(content, layer); err != nil {
+		return nil, fmt.Errorf("parse layer %s error %v", diffID, err)
+	}
+	return layer, nil
+}
+
+// ImportLayer 导入一个层的tar包(可以是gzip或zstd压缩的)，按未压缩内容的sha256存放，已经存在时直接复用
+func ImportLayer(r io.Reader) (*Layer, error) {
+	return importLayer(r, "")
+}
+
+// 导入层的tar包，expected不为空时校验未压缩内容的sha256，不一致时不解压
+func importLayer(r io.Reader, expected string) (*Layer, error) {
+	if err := os.MkdirAll(vars.LayersDir, 0755); err != nil {
+		return nil, fmt.Errorf("mkdir %s error %v", vars.LayersDir, err)
+	}
+	reader, err := decompress(r)
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+
+	// 先写入临时文件并计算摘要：tar读到归档结束标记就会退出，不一定读完整个流
+	tmpFile, err := os.CreateTemp(vars.LayersDir, "tmp-layer-")
+	if err != nil {
+		return nil, fmt.Errorf("create temp layer file error %v", err)
+	}
+	defer os.Remove(tmpFile.Name())
+	defer tmpFile.Close()
+	hash := sha256.New()
+	size, err := io.Copy(io.MultiWriter(tmpFile, hash), reader)
+	if err != nil {
+		return nil, fmt.Errorf("read layer error %v", err)
+	}
+	layer := &Layer{DiffID: "sha256:" + hex.EncodeToString(hash.Sum(nil)), Size: size}
+	if expected != "" && layer.DiffID != expected {
+		return nil, fmt.Errorf("layer digest mismatch, expected %s, got %s", expected, layer.DiffID)
+	}
+	if _, err := GetLayer(layer.DiffID); err == nil {
+		return layer, nil
+	}
+
+	// 解压到临时目录，完成后重命名，中途失败不会留下不完整的层
+	tmpDir, err := os.MkdirTemp(vars.LayersDir, "tmp-")
+	if err != nil {
+		return nil, fmt.Errorf("create temp layer dir error %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+	diffDir := path.Join(tmpDir, "diff")
+	if err := os.Mkdir(diffDir, 0755); err != nil {
+		return nil, fmt.Errorf("mkdir %s error %v", diffDir, err)
+	}
+	if _, err := tmpFile.Seek(0, io.SeekStart); err != nil {
+		return nil, fmt.Errorf("seek temp layer file error %v", err)
+	}
+	if err := untar(tmpFile, diffDir); err != nil {
+		return nil, fmt.Errorf("untar layer %s error %v", layer.DiffID, err)
+	}
+	if err := convertWhiteouts(diffDir); err != nil {
+		return nil, err
+	}
+	content, err := json.Marshal(layer)
+	if err != nil {
+		return nil, fmt.Errorf("marshal layer error %v", err)
+	}
+	if err := os.WriteFile(path.Join(tmpDir, "layer.json"), content, 0644); err != nil {
+		return nil, fmt.Errorf("write layer %s metadata error %v", layer.DiffID, err)
+	}
+	if err := os.Rename(tmpDir, layerDir(layer.DiffID)); err != nil {
+		// 同时导入相同的层时，另一个进程已经完成
+		if _, getErr := GetLayer(layer.DiffID); getErr == nil {
+			return layer, nil
+		}
+		return nil, fmt.Errorf("rename layer %s error %v", layer.DiffID, err)
+	}
+	return layer, nil
+}
+
+// 根据文件头判断压缩格式并解压，未压缩时原样返回
+func decompress(r io.Reader) (io.ReadCloser, error) {
+	br := bufio.NewReader(r)
+	magic, err := br.Peek(4)
+	if err != nil && err != io.EOF {
+		return nil, fmt.Errorf("read layer header error %v", err)
+	}
+	switch {
+	case bytes.HasPrefix(magic, []byte{0x1f, 0x8b}):
+		gz, err := gzip.NewReader(br)
+		if err != nil {
+			return nil, fmt.Errorf("new gzip reader error %v", err)
+		}
+		return gz, nil
+	case bytes.Equal(magic, []byte{0x28, 0xb5, 0x2f, 0xfd}):
+		zr, err := zstd.NewReader(br)
+		if err != nil {
+			return nil, fmt.Errorf("new zstd reader error %v", err)
+		}
+		return zr.IOReadCloser(), nil
+	}
+	return io.NopCloser(br), nil
+}
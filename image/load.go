@@ -0,0 +1,296 @@
+package image
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mydocker/vars"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+)
+
+/*
+	导入其他工具导出的镜像：
+	docker save的tar包：manifest.json列出每个镜像的config、标签(RepoTags)和从底层开始的各层tar包
+	OCI image-layout(目录或tar包)：index.json指向各镜像的manifest(或多平台的index)，内容都在blobs/<算法>/<摘要>下
+	同时有manifest.json和index.json时(docker 25之后的docker save)，按manifest.json导入
+*/
+
+const (
+	mediaTypeOCIIndex   = "application/vnd.oci.image.index.v1+json"
+	mediaTypeDockerList = "application/vnd.docker.distribution.manifest.list.v2+json"
+	annotationRefName   = "org.opencontainers.image.ref.name"
+	annotationImageName = "io.containerd.image.name"
+	defaultRegistryLib  = "docker.io/library/"
+)
+
+// docker save的manifest.json中的一项
+type dockerManifest struct {
+	Config   string   `json:"Config"`
+	RepoTags []string `json:"RepoTags"`
+	Layers   []string `json:"Layers"`
+}
+
+// OCI image-layout的index.json或多平台镜像的index
+type ociIndex struct {
+	SchemaVersion int          `json:"schemaVersion"`
+	MediaType     string       `json:"mediaType,omitempty"`
+	Manifests     []Descriptor `json:"manifests"`
+}
+
+// LoadedImage 导入的一个镜像，Names为打上的标签
+type LoadedImage struct {
+	ID    string
+	Names []string
+}
+
+// Load 导入docker save的tar包、OCI image-layout的目录或tar包，校验各层的摘要后写入镜像存储并打上标签
+func Load(input string) ([]LoadedImage, error) {
+	info, err := os.Stat(input)
+	if err != nil {
+		return nil, fmt.Errorf("stat %s error %v", input, err)
+	}
+	root := input
+	if !info.IsDir() {
+		if err := os.MkdirAll(vars.ImageRootPath, 0755); err != nil {
+			return nil, fmt.Errorf("mkdir %s error %v", vars.ImageRootPath, err)
+		}
+		if root, err = os.MkdirTemp(vars.ImageRootPath, "tmp-load-"); err != nil {
+			return nil, fmt.Errorf("create temp dir error %v", err)
+		}
+		defer os.RemoveAll(root)
+		if err := untarFile(input, root); err != nil {
+			return nil, err
+		}
+	}
+
+	if _, err := os.Stat(filepath.Join(root, "manifest.json")); err == nil {
+		return loadDockerArchive(root)
+	}
+	if _, err := os.Stat(filepath.Join(root, "index.json")); err == nil {
+		return loadOCILayout(root)
+	}
+	return nil, fmt.Errorf("%s is neither a docker save archive nor an oci image layout", input)
+}
+
+// 解压导入的归档文件，可以是gzip或zstd压缩的(如docker save | gzip)
+func untarFile(input, dir string) error {
+	f, err := os.Open(input)
+	if err != nil {
+		return fmt.Errorf("open %s error %v", input, err)
+	}
+	defer f.Close()
+	reader, err := decompress(f)
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+	if err := untar(reader, dir); err != nil {
+		return fmt.Errorf("untar %s error %v", input, err)
+	}
+	return nil
+}
+
+func loadDockerArchive(root string) ([]LoadedImage, error) {
+	var manifests []dockerManifest
+	if err := readJSON(root, "manifest.json", &manifests); err != nil {
+		return nil, err
+	}
+	var loaded []LoadedImage
+	for _, m := range manifests {
+		configPath, err := safeJoin(root, m.Config)
+		if err != nil {
+			return nil, err
+		}
+		content, err := os.ReadFile(configPath)
+		if err != nil {
+			return nil, fmt.Errorf("read image config %s error %v", m.Config, err)
+		}
+		// 旧格式的config文件名为<摘要>.json，新格式为blobs/sha256/<摘要>
+		if name := strings.TrimSuffix(filepath.Base(m.Config), ".json"); len(name) == sha256.Size*2 && Digest(content) != "sha256:"+name {
+			return nil, fmt.Errorf("image config %s digest mismatch", m.Config)
+		}
+
+		layers := make([]string, 0, len(m.Layers))
+		for _, layer := range m.Layers {
+			layerPath, err := safeJoin(root, layer)
+			if err != nil {
+				return nil, err
+			}
+			layers = append(layers, layerPath)
+		}
+		img, err := loadImage(content, layers)
+		if err != nil {
+			return nil, err
+		}
+		for _, name := range m.RepoTags {
+			if err := Tag(name, img.ID); err != nil {
+				return nil, err
+			}
+			img.Names = append(img.Names, NormalizeName(name))
+		}
+		loaded = append(loaded, *img)
+	}
+	return loaded, nil
+}
+
+func loadOCILayout(root string) ([]LoadedImage, error) {
+	index := &ociIndex{}
+	if err := readJSON(root, "index.json", index); err != nil {
+		return nil, err
+	}
+	var loaded []LoadedImage
+	for _, desc := range index.Manifests {
+		manifestDesc, err := selectManifest(root, desc)
+		if err != nil {
+			return nil, err
+		}
+		manifest := &Manifest{}
+		if err := readBlobJSON(root, manifestDesc, manifest); err != nil {
+			return nil, err
+		}
+		configPath, err := verifyBlob(root, manifest.Config)
+		if err != nil {
+			return nil, err
+		}
+		content, err := os.ReadFile(configPath)
+		if err != nil {
+			return nil, fmt.Errorf("read image config error %v", err)
+		}
+
+		layers := make([]string, 0, len(manifest.Layers))
+		for _, layer := range manifest.Layers {
+			layerPath, err := verifyBlob(root, layer)
+			if err != nil {
+				return nil, err
+			}
+			layers = append(layers, layerPath)
+		}
+		img, err := loadImage(content, layers)
+		if err != nil {
+			return nil, err
+		}
+		if name := ociImageName(desc.Annotations); name != "" {
+			if err := Tag(name, img.ID); err != nil {
+				return nil, err
+			}
+			img.Names = append(img.Names, NormalizeName(name))
+		}
+		loaded = append(loaded, *img)
+	}
+	return loaded, nil
+}
+
+// 多平台的镜像从index中选出当前平台的manifest
+func selectManifest(root string, desc Descriptor) (Descriptor, error) {
+	if desc.MediaType != mediaTypeOCIIndex && desc.MediaType != mediaTypeDockerList {
+		return desc, nil
+	}
+	index := &ociIndex{}
+	if err := readBlobJSON(root, desc, index); err != nil {
+		return desc, err
+	}
+	for _, m := range index.Manifests {
+		if m.Platform == nil || (m.Platform.OS == "linux" && m.Platform.Architecture == runtime.GOARCH) {
+			return selectManifest(root, m)
+		}
+	}
+	return desc, fmt.Errorf("no manifest for linux/%s in %s", runtime.GOARCH, desc.Digest)
+}
+
+// index.json中镜像的名称：优先使用containerd记录的完整名称，ref.name只有tag时无法确定仓库名，不打标签
+func ociImageName(annotations map[string]string) string {
+	name := annotations[annotationImageName]
+	if name == "" {
+		if ref := annotations[annotationRefName]; strings.ContainsAny(ref, ":/") {
+			name = ref
+		}
+	}
+	return strings.TrimPrefix(name, defaultRegistryLib)
+}
+
+// 按config中的diff_ids校验并导入各层，然后按config的原始内容保存镜像
+func loadImage(content []byte, layers []string) (*LoadedImage, error) {
+	config := &Config{}
+	if err := json.Unmarshal(content, config); err != nil {
+		return nil, fmt.Errorf("parse image config error %v", err)
+	}
+	if len(config.RootFS.DiffIDs) != len(layers) {
+		return nil, fmt.Errorf("image config has %d layers, but %d layers found", len(config.RootFS.DiffIDs), len(layers))
+	}
+	for i, layerPath := range layers {
+		f, err := os.Open(layerPath)
+		if err != nil {
+			return nil, fmt.Errorf("open layer %s error %v", layerPath, err)
+		}
+		_, err = importLayer(f, config.RootFS.DiffIDs[i])
+		f.Close()
+		if err != nil {
+			return nil, err
+		}
+	}
+	id, err := createImage(content, config)
+	if err != nil {
+		return nil, err
+	}
+	return &LoadedImage{ID: id}, nil
+}
+
+// 校验blob的摘要和大小，返回blob的路径
+func verifyBlob(root string, desc Descriptor) (string, error) {
+	parts := strings.SplitN(desc.Digest, ":", 2)
+	if len(parts) != 2 || parts[0] != "sha256" || len(parts[1]) != sha256.Size*2 {
+		return "", fmt.Errorf("unsupported digest %s", desc.Digest)
+	}
+	algorithm, encoded := parts[0], parts[1]
+	blobPath, err := safeJoin(root, filepath.Join("blobs", algorithm, encoded))
+	if err != nil {
+		return "", err
+	}
+	f, err := os.Open(blobPath)
+	if err != nil {
+		return "", fmt.Errorf("open blob %s error %v", desc.Digest, err)
+	}
+	defer f.Close()
+	hash := sha256.New()
+	size, err := io.Copy(hash, f)
+	if err != nil {
+		return "", fmt.Errorf("read blob %s error %v", desc.Digest, err)
+	}
+	if hex.EncodeToString(hash.Sum(nil)) != encoded || (desc.Size > 0 && size != desc.Size) {
+		return "", fmt.Errorf("blob %s digest mismatch", desc.Digest)
+	}
+	return blobPath, nil
+}
+
+func readBlobJSON(root string, desc Descriptor, v interface{}) error {
+	blobPath, err := verifyBlob(root, desc)
+	if err != nil {
+		return err
+	}
+	return readJSON(filepath.Dir(blobPath), filepath.Base(blobPath), v)
+}
+
+func readJSON(dir, name string, v interface{}) error {
+	content, err := os.ReadFile(filepath.Join(dir, name))
+	if err != nil {
+		return fmt.Errorf("read %s error %v", name, err)
+	}
+	if err := json.Unmarshal(content, v); err != nil {
+		return fmt.Errorf("parse %s error %v", name, err)
+	}
+	return nil
+}
+
+// 归档中的路径不能指向归档目录之外
+func safeJoin(root, name string) (string, error) {
+	joined := filepath.Join(root, name)
+	if rel, err := filepath.Rel(root, joined); err != nil || rel == ".." || strings.HasPrefix(rel, "../") {
+		return "", fmt.Errorf("invalid path %s in archive", name)
+	}
+	return joined, nil
+}
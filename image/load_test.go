@@ -0,0 +1,103 @@
+package image
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/klauspost/compress/zstd"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func writeJSON(t *testing.T, filePath string, v interface{}) []byte {
+	content, err := json.Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.MkdirAll(filepath.Dir(filePath), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filePath, content, 0644); err != nil {
+		t.Fatal(err)
+	}
+	return content
+}
+
+func TestLoadDockerArchive(t *testing.T) {
+	setupStore(t)
+	dir := t.TempDir()
+	layer := tarFile(t, "hello", "docker")
+	os.MkdirAll(filepath.Join(dir, "l1"), 0755)
+	os.WriteFile(filepath.Join(dir, "l1", "layer.tar"), layer, 0644)
+	config := NewConfig()
+	config.RootFS.DiffIDs = []string{Digest(layer)}
+	content, _ := json.Marshal(config)
+	configName := digestHex(Digest(content)) + ".json"
+	os.WriteFile(filepath.Join(dir, configName), content, 0644)
+	writeJSON(t, filepath.Join(dir, "manifest.json"), []dockerManifest{{Config: configName, RepoTags: []string{"saved:1.0"}, Layers: []string{"l1/layer.tar"}}})
+
+	loaded, err := Load(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(loaded) != 1 || loaded[0].ID != Digest(content) || len(loaded[0].Names) != 1 || loaded[0].Names[0] != "saved:1.0" {
+		t.Errorf("unexpected loaded images %+v", loaded)
+	}
+	if id, err := Resolve("saved:1.0"); err != nil || id != Digest(content) {
+		t.Errorf("resolve saved:1.0: got %s %v", id, err)
+	}
+
+	// 层的内容与config中的diff_ids不一致
+	os.WriteFile(filepath.Join(dir, "l1", "layer.tar"), tarFile(t, "hello", "changed"), 0644)
+	if _, err := Load(dir); err == nil {
+		t.Errorf("layer digest mismatch should fail")
+	}
+}
+
+func TestLoadOCILayout(t *testing.T) {
+	setupStore(t)
+	dir := t.TempDir()
+	writeBlob := func(content []byte) Descriptor {
+		digest := Digest(content)
+		blobPath := filepath.Join(dir, "blobs", "sha256", digestHex(digest))
+		os.MkdirAll(filepath.Dir(blobPath), 0755)
+		if err := os.WriteFile(blobPath, content, 0644); err != nil {
+			t.Fatal(err)
+		}
+		return Descriptor{Digest: digest, Size: int64(len(content))}
+	}
+
+	layer := tarFile(t, "hello", "oci")
+	encoder, err := zstd.NewWriter(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	layerDesc := writeBlob(encoder.EncodeAll(layer, nil))
+	layerDesc.MediaType = "application/vnd.oci.image.layer.v1.tar+zstd"
+	config := NewConfig()
+	config.RootFS.DiffIDs = []string{Digest(layer)}
+	content, _ := json.Marshal(config)
+	configDesc := writeBlob(content)
+	manifestContent, _ := json.Marshal(Manifest{SchemaVersion: 2, MediaType: MediaTypeManifest, Config: configDesc, Layers: []Descriptor{layerDesc}})
+	manifestDesc := writeBlob(manifestContent)
+	manifestDesc.MediaType = MediaTypeManifest
+	manifestDesc.Annotations = map[string]string{annotationImageName: "docker.io/library/oci:v1"}
+	writeJSON(t, filepath.Join(dir, "index.json"), ociIndex{SchemaVersion: 2, Manifests: []Descriptor{manifestDesc}})
+
+	loaded, err := Load(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(loaded) != 1 || len(loaded[0].Names) != 1 || loaded[0].Names[0] != "oci:v1" {
+		t.Errorf("unexpected loaded images %+v", loaded)
+	}
+	if got, err := os.ReadFile(filepath.Join(LayerPath(Digest(layer)), "hello")); err != nil || string(got) != "oci" {
+		t.Errorf("unexpected layer content %q %v", got, err)
+	}
+
+	// blob被篡改
+	os.WriteFile(filepath.Join(dir, "blobs", "sha256", digestHex(configDesc.Digest)), []byte(fmt.Sprintf("%s ", content)), 0644)
+	if _, err := Load(dir); err == nil {
+		t.Errorf("blob digest mismatch should fail")
+	}
+}
@@ -0,0 +1,101 @@
+package image
+
+import (
+	"encoding/json"
+	"fmt"
+	"mydocker/vars"
+	"os"
+	"sort"
+	"strings"
+)
+
+// DefaultTag 镜像名没有带tag时使用的tag
+const DefaultTag = "latest"
+
+// ParseReference 将镜像名拆分为仓库名和tag，没有tag时为latest
+// 仓库名中可能带有registry的端口(localhost:5000/busybox)，只有最后一个/之后的冒号才是tag的分隔符
+func ParseReference(ref string) (string, string) {
+	i := strings.LastIndex(ref, ":")
+	if i < 0 || strings.Contains(ref[i+1:], "/") {
+		return ref, DefaultTag
+	}
+	return ref[:i], ref[i+1:]
+}
+
+// NormalizeName 返回name:tag形式的镜像名
+func NormalizeName(ref string) string {
+	repo, tag := ParseReference(ref)
+	return repo + ":" + tag
+}
+
+// Tags 返回指向镜像的全部name:tag，按名称排序
+func Tags(id string) ([]string, error) {
+	repositories, err := loadRepositories()
+	if err != nil {
+		return nil, err
+	}
+	var tags []string
+	for name, imageID := range repositories {
+		if imageID == id {
+			tags = append(tags, name)
+		}
+	}
+	sort.Strings(tags)
+	return tags, nil
+}
+
+// 读取name:tag到镜像ID的索引
+func loadRepositories() (map[string]string, error) {
+	repositories := map[string]string{}
+	content, err := os.ReadFile(vars.RepositoriesFile)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return repositories, nil
+		}
+		return nil, fmt.Errorf("read %s error %v", vars.RepositoriesFile, err)
+	}
+	if err := json.Unmarshal(content, &repositories); err != nil {
+		return nil, fmt.Errorf("parse %s error %v", vars.RepositoriesFile, err)
+	}
+	return repositories, nil
+}
+
+func saveRepositories(repositories map[string]string) error {
+	content, err := json.MarshalIndent(repositories, "", "  ")
+	if err != nil {
+		return fmt.Errorf("marshal repositories error %v", err)
+	}
+	if err := os.MkdirAll(vars.ImageRootPath, 0755); err != nil {
+		return fmt.Errorf("mkdir %s error %v", vars.ImageRootPath, err)
+	}
+	return writeFileAtomic(vars.RepositoriesFile, content)
+}
+
+// Tag 为镜像打上name[:tag]标签，同名的标签指向新的镜像
+func Tag(ref, id string) error {
+	repo, tag := ParseReference(ref)
+	if repo == "" || tag == "" || strings.ContainsAny(ref, " \t") {
+		return fmt.Errorf("invalid image name %s", ref)
+	}
+	repositories, err := loadRepositories()
+	if err != nil {
+		return err
+	}
+	repositories[repo+":"+tag] = id
+	return saveRepositories(repositories)
+}
+
+// Untag 删除镜像名name[:tag]，返回它指向的镜像ID
+func Untag(ref string) (string, error) {
+	repositories, err := loadRepositories()
+	if err != nil {
+		return "", err
+	}
+	name := NormalizeName(ref)
+	id, ok := repositories[name]
+	if !ok {
+		return "", fmt.Errorf("no such image %s", ref)
+	}
+	delete(repositories, name)
+	return id, saveRepositories(repositories)
+}
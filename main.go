@@ -18,13 +18,26 @@ func main() {
 	app.Commands = []cli.Command{
 		initCommand,
 		runCommand,
+		shimCommand,
 		commitCommand,
 		listCommand,
 		logCommand,
 		execCommand,
 		stopCommand,
+		killCommand,
+		pauseCommand,
+		unpauseCommand,
+		startCommand,
+		restartCommand,
 		removeCommand,
+		imagesCommand,
+		removeImageCommand,
+		tagCommand,
+		loadCommand,
+		statsCommand,
+		updateCommand,
 		networkCommand,
+		ociCommand,
 	}
 
 	// cmd.Context 用于检索args，解析命令行的options
@@ -8,13 +8,127 @@ import (
 	mycli "mydocker/cmd"
 	"mydocker/container"
 	"mydocker/network"
+	"path/filepath"
+	"time"
 )
 
+// 资源限制相关的参数，run和update共用
+var resourceFlags = []cli.Flag{
+	// 设置内存
+	cli.StringFlag{
+		Name:  "m",
+		Usage: "memory limit",
+	},
+	cli.StringFlag{
+		Name:  "memory-swap",
+		Usage: "swap limit equal to memory plus swap: '-1' to enable unlimited swap",
+	},
+	cli.StringFlag{
+		Name:  "memory-reservation",
+		Usage: "memory soft limit",
+	},
+	cli.StringFlag{
+		Name:  "kernel-memory",
+		Usage: "kernel memory limit",
+	},
+	cli.BoolFlag{
+		Name:  "oom-kill-disable",
+		Usage: "disable OOM killer",
+	},
+	cli.StringFlag{
+		Name:  "oom-score-adj",
+		Usage: "tune host's OOM preferences (-1000 to 1000)",
+	},
+	cli.StringFlag{
+		Name:  "cpushare",
+		Usage: "cpushare limit",
+	},
+	cli.StringFlag{
+		Name:  "cpuset",
+		Usage: "cpuset limit",
+	},
+	// 设置cpu硬限制
+	cli.StringFlag{
+		Name:  "cpus",
+		Usage: "number of CPUs, e.g. 1.5",
+	},
+	cli.StringFlag{
+		Name:  "cpu-period",
+		Usage: "limit CPU CFS (Completely Fair Scheduler) period in microseconds",
+	},
+	cli.StringFlag{
+		Name:  "cpu-quota",
+		Usage: "limit CPU CFS (Completely Fair Scheduler) quota in microseconds",
+	},
+	// 设置块设备io限制
+	cli.StringFlag{
+		Name:  "blkio-weight",
+		Usage: "block IO weight (relative weight), between 10 and 1000",
+	},
+	cli.StringSliceFlag{
+		Name:  "device-read-bps",
+		Usage: "limit read rate (bytes per second) from a device, e.g. /dev/sda:1mb",
+	},
+	cli.StringSliceFlag{
+		Name:  "device-write-bps",
+		Usage: "limit write rate (bytes per second) to a device, e.g. /dev/sda:1mb",
+	},
+	cli.StringSliceFlag{
+		Name:  "device-read-iops",
+		Usage: "limit read rate (IO per second) from a device, e.g. /dev/sda:1000",
+	},
+	cli.StringSliceFlag{
+		Name:  "device-write-iops",
+		Usage: "limit write rate (IO per second) to a device, e.g. /dev/sda:1000",
+	},
+	// 设置进程数限制
+	cli.StringFlag{
+		Name:  "pids-limit",
+		Usage: "tune container pids limit (set -1 for unlimited)",
+	},
+}
+
+// 从命令行参数中解析资源限制
+func parseResourceConfig(context *cli.Context) (*subsystems.ResourceConfig, error) {
+	resConf := &subsystems.ResourceConfig{
+		MemoryLimit:       context.String("m"), // 能够获取参数对应的值
+		MemorySwap:        context.String("memory-swap"),
+		MemoryReservation: context.String("memory-reservation"),
+		KernelMemory:      context.String("kernel-memory"),
+		OomScoreAdj:       context.String("oom-score-adj"),
+		CpuSet:            context.String("cpuset"),
+		CpuShare:          context.String("cpushare"),
+		Cpus:              context.String("cpus"),
+		CpuPeriod:         context.String("cpu-period"),
+		CpuQuota:          context.String("cpu-quota"),
+		BlkioWeight:       context.String("blkio-weight"),
+		DeviceReadBps:     context.StringSlice("device-read-bps"),
+		DeviceWriteBps:    context.StringSlice("device-write-bps"),
+		DeviceReadIOps:    context.StringSlice("device-read-iops"),
+		DeviceWriteIOps:   context.StringSlice("device-write-iops"),
+		PidsLimit:         context.String("pids-limit"),
+	}
+	// 只有显式指定时才设置，--oom-kill-disable=false可以重新开启oom killer
+	if context.IsSet("oom-kill-disable") {
+		oomKillDisable := context.Bool("oom-kill-disable")
+		resConf.OomKillDisable = &oomKillDisable
+	}
+	if err := resConf.Validate(); err != nil {
+		return nil, err
+	}
+	return resConf, nil
+}
+
 // Flags的作用类似于运行命令时使用--来指定参数
 var runCommand = cli.Command{
 	Name:  "run",
 	Usage: `Create a container with namespace and cgroups limit mydocker run -ti [command]`,
-	Flags: []cli.Flag{
+	Flags: append([]cli.Flag{
+		// 容器配置文件
+		cli.StringFlag{
+			Name:  "config",
+			Usage: "load container spec from a json/yaml file, flags override values in the file",
+		},
 		// 交互模式
 		cli.BoolFlag{
 			Name:  "ti",
@@ -26,22 +140,9 @@ var runCommand = cli.Command{
 			Usage: "detach container",
 		},
 		// 设置挂载
-		cli.StringFlag{
+		cli.StringSliceFlag{
 			Name:  "v",
-			Usage: "volume",
-		},
-		// 设置内存
-		cli.StringFlag{
-			Name:  "m",
-			Usage: "memory limit",
-		},
-		cli.StringFlag{
-			Name:  "cpushare",
-			Usage: "cpushare limit",
-		},
-		cli.StringFlag{
-			Name:  "cpuset",
-			Usage: "cpuset limit",
+			Usage: "volume, host:container",
 		},
 		// 设置容器名
 		cli.StringFlag{
@@ -63,7 +164,39 @@ var runCommand = cli.Command{
 			Name:  "p",
 			Usage: "port mapping",
 		},
-	},
+		// 设置重启策略
+		cli.StringFlag{
+			Name:  "restart",
+			Value: "no",
+			Usage: "restart policy to apply when a detached container exits, no|on-failure[:max-retries]|always|unless-stopped",
+		},
+		// 使用mydocker init作为容器的1号进程
+		cli.BoolFlag{
+			Name:  "init",
+			Usage: "run an init inside the container that forwards signals and reaps processes",
+		},
+		// stop时发送给容器的信号
+		cli.StringFlag{
+			Name:  "stop-signal",
+			Value: "SIGTERM",
+			Usage: "signal to stop the container",
+		},
+		// 容器的主机名
+		cli.StringFlag{
+			Name:  "hostname",
+			Usage: "container host name, defaults to the container id",
+		},
+		// 用户命令的工作目录
+		cli.StringFlag{
+			Name:  "workdir, w",
+			Usage: "working directory inside the container",
+		},
+		// 运行用户命令的用户
+		cli.StringFlag{
+			Name:  "user, u",
+			Usage: "username or uid, format: <name|uid>[:<group|gid>]",
+		},
+	}, resourceFlags...),
 	/*
 		这里是run命令执行的真正函数。
 		1. 判断参数是否包含command
@@ -73,37 +206,91 @@ var runCommand = cli.Command{
 
 	// cmd.Context 用于检索args，解析命令行的options
 	Action: func(context *cli.Context) error {
-		// 非flag会被归到args！！！！！
-		if len(context.Args()) < 1 {
-			return fmt.Errorf("Missing container command")
-		}
-		var commandArray []string
-		for _, arg := range context.Args() {
-			commandArray = append(commandArray, arg)
+		if context.Bool("ti") && context.Bool("d") {
+			return fmt.Errorf("ti and d parameter can not both provided")
 		}
 
-		resConf := &subsystems.ResourceConfig{
-			MemoryLimit: context.String("m"), // 能够获取参数对应的值
-			CpuSet:      context.String("cpuset"),
-			CpuShare:    context.String("cpushare"),
+		spec, err := parseSpec(context)
+		if err != nil {
+			return err
 		}
 
-		createTty := context.Bool("ti")
-		volume := context.String("v")
-		detach := context.Bool("d")
-		containerName := context.String("name")
-		env := context.StringSlice("e")
-		networkName := context.String("net")
-		portMapping := context.StringSlice("p")
+		log.Infof("createTty %v", spec.Tty)
+		mycli.Run(spec)
+		return nil
+	},
+}
 
-		if createTty && detach {
-			return fmt.Errorf("ti and d parameter can not both provided")
+// 先从--config加载spec，再用命令行中指定了的参数覆盖
+func parseSpec(context *cli.Context) (*container.Spec, error) {
+	spec := &container.Spec{}
+	if file := context.String("config"); file != "" {
+		var err error
+		if spec, err = container.LoadSpec(file); err != nil {
+			return nil, err
 		}
+	}
 
-		log.Infof("createTty %v", createTty)
-		mycli.Run(createTty, commandArray, resConf, volume, containerName, env, networkName, portMapping)
-		return nil
-	},
+	// 非flag会被归到args！！！！！第一个为镜像名，其余为容器命令
+	if len(context.Args()) > 0 {
+		spec.Image = context.Args().Get(0)
+		if len(context.Args()) > 1 {
+			spec.Command = context.Args()[1:]
+		}
+	}
+
+	if context.IsSet("name") {
+		spec.Name = context.String("name")
+	}
+	if context.IsSet("ti") {
+		spec.Tty = context.Bool("ti")
+	}
+	if context.IsSet("d") {
+		spec.Tty = false
+	}
+	if context.IsSet("v") {
+		spec.Volumes = context.StringSlice("v")
+	}
+	// 命令行的环境变量与配置文件合并，同名时命令行的生效
+	spec.Env = container.MergeEnv(spec.Env, context.StringSlice("e"))
+	if context.IsSet("net") {
+		spec.Network = context.String("net")
+	}
+	if context.IsSet("p") {
+		spec.Ports = context.StringSlice("p")
+	}
+	if context.IsSet("restart") {
+		spec.RestartPolicy = context.String("restart")
+	}
+	if context.IsSet("stop-signal") {
+		spec.StopSignal = context.String("stop-signal")
+	}
+	if context.IsSet("init") {
+		spec.Init = context.Bool("init")
+	}
+	if context.IsSet("hostname") {
+		spec.Hostname = context.String("hostname")
+	}
+	if context.IsSet("workdir") {
+		spec.Workdir = context.String("workdir")
+	}
+	if context.IsSet("user") {
+		spec.User = context.String("user")
+	}
+
+	resConf, err := parseResourceConfig(context)
+	if err != nil {
+		return nil, err
+	}
+	if spec.Resources == nil {
+		spec.Resources = &subsystems.ResourceConfig{}
+	}
+	spec.Resources.Merge(resConf)
+
+	if err := spec.Validate(); err != nil {
+		return nil, err
+	}
+	return spec, nil
 }
 
 var initCommand = cli.Command{
@@ -122,16 +309,40 @@ var initCommand = cli.Command{
 	},
 }
 
+var shimCommand = cli.Command{
+	Name:  "shim",
+	Usage: "Supervise a detached container process. Do not call it outside",
+	Action: func(context *cli.Context) error {
+		if len(context.Args()) < 1 {
+			return fmt.Errorf("Missing container name")
+		}
+		return mycli.RunShim(context.Args().Get(0))
+	},
+}
+
 var commitCommand = cli.Command{
 	Name:  "commit",
-	Usage: "commit <containerName> <imageName>",
+	Usage: "create a new image from a container's changes, mydocker commit [options] <containerName> [imageName]",
+	Flags: []cli.Flag{
+		cli.StringFlag{
+			Name:  "author, a",
+			Usage: "author of the image",
+		},
+		cli.StringFlag{
+			Name:  "message, m",
+			Usage: "commit message",
+		},
+	},
 	Action: func(context *cli.Context) error {
 		if len(context.Args()) < 1 {
 			return fmt.Errorf("missing container name")
 		}
 		containerName := context.Args().Get(0)
 		imageName := context.Args().Get(1)
-		mycli.CommitContainer(containerName, imageName)
+		mycli.CommitContainer(containerName, imageName, mycli.CommitOptions{
+			Author:  context.String("author"),
+			Message: context.String("message"),
+		})
 		return nil
 	},
 }
@@ -183,12 +394,98 @@ var execCommand = cli.Command{
 var stopCommand = cli.Command{
 	Name:  "stop",
 	Usage: "stop a container",
+	Flags: []cli.Flag{
+		cli.IntFlag{
+			Name:  "t",
+			Value: 10,
+			Usage: "seconds to wait for stop before killing it",
+		},
+	},
+	Action: func(context *cli.Context) error {
+		if len(context.Args()) < 1 {
+			return fmt.Errorf("Missing container name")
+		}
+		containerName := context.Args().Get(0)
+		mycli.StopContainer(containerName, time.Duration(context.Int("t"))*time.Second)
+		return nil
+	},
+}
+
+var killCommand = cli.Command{
+	Name:  "kill",
+	Usage: "send a signal to a container",
+	Flags: []cli.Flag{
+		cli.StringFlag{
+			Name:  "s",
+			Value: "KILL",
+			Usage: "signal to send to the container, name(HUP/SIGHUP) or number",
+		},
+	},
 	Action: func(context *cli.Context) error {
 		if len(context.Args()) < 1 {
 			return fmt.Errorf("Missing container name")
 		}
 		containerName := context.Args().Get(0)
-		mycli.StopContainer(containerName)
+		mycli.KillContainer(containerName, context.String("s"))
+		return nil
+	},
+}
+
+var pauseCommand = cli.Command{
+	Name:  "pause",
+	Usage: "pause all processes within a container",
+	Action: func(context *cli.Context) error {
+		if len(context.Args()) < 1 {
+			return fmt.Errorf("Missing container name")
+		}
+		containerName := context.Args().Get(0)
+		mycli.PauseContainer(containerName)
+		return nil
+	},
+}
+
+var unpauseCommand = cli.Command{
+	Name:  "unpause",
+	Usage: "unpause all processes within a container",
+	Action: func(context *cli.Context) error {
+		if len(context.Args()) < 1 {
+			return fmt.Errorf("Missing container name")
+		}
+		containerName := context.Args().Get(0)
+		mycli.UnpauseContainer(containerName)
+		return nil
+	},
+}
+
+var startCommand = cli.Command{
+	Name:  "start",
+	Usage: "start a stopped container",
+	Action: func(context *cli.Context) error {
+		if len(context.Args()) < 1 {
+			return fmt.Errorf("Missing container name")
+		}
+		containerName := context.Args().Get(0)
+		mycli.StartContainer(containerName)
+		return nil
+	},
+}
+
+var restartCommand = cli.Command{
+	Name:  "restart",
+	Usage: "restart a container",
+	Flags: []cli.Flag{
+		cli.IntFlag{
+			Name:  "t",
+			Value: 10,
+			Usage: "seconds to wait for stop before killing it",
+		},
+	},
+	Action: func(context *cli.Context) error {
+		if len(context.Args()) < 1 {
+			return fmt.Errorf("Missing container name")
+		}
+		containerName := context.Args().Get(0)
+		mycli.RestartContainer(containerName, time.Duration(context.Int("t"))*time.Second)
 		return nil
 	},
 }
@@ -206,6 +503,109 @@ var removeCommand = cli.Command{
 	},
 }
 
+var imagesCommand = cli.Command{
+	Name:  "images",
+	Usage: "list images",
+	Action: func(context *cli.Context) error {
+		mycli.ListImages()
+		return nil
+	},
+}
+
+var removeImageCommand = cli.Command{
+	Name:  "rmi",
+	Usage: "remove images, mydocker rmi [-f] <image>...",
+	Flags: []cli.Flag{
+		cli.BoolFlag{
+			Name:  "f",
+			Usage: "force removal of images used by containers or with multiple tags",
+		},
+	},
+	Action: func(context *cli.Context) error {
+		if len(context.Args()) < 1 {
+			return fmt.Errorf("Missing image name")
+		}
+		for _, ref := range context.Args() {
+			mycli.RemoveImage(ref, context.Bool("f"))
+		}
+		return nil
+	},
+}
+
+var loadCommand = cli.Command{
+	Name:  "load",
+	Usage: "load images from a docker save archive or an oci image layout, mydocker load -i <file.tar|dir>",
+	Flags: []cli.Flag{
+		cli.StringFlag{
+			Name:  "input, i",
+			Usage: "read from a tar archive or an oci image layout directory",
+		},
+	},
+	Action: func(context *cli.Context) error {
+		input := context.String("input")
+		if input == "" {
+			return fmt.Errorf("Missing input file")
+		}
+		mycli.LoadImage(input)
+		return nil
+	},
+}
+
+var tagCommand = cli.Command{
+	Name:  "tag",
+	Usage: "create a tag that refers to an image, mydocker tag <source> <target>",
+	Action: func(context *cli.Context) error {
+		if len(context.Args()) < 2 {
+			return fmt.Errorf("Missing source or target image name")
+		}
+		mycli.TagImage(context.Args().Get(0), context.Args().Get(1))
+		return nil
+	},
+}
+
+var updateCommand = cli.Command{
+	Name:  "update",
+	Usage: "update resource limits of a running container, mydocker update [options] <containerName>",
+	Flags: resourceFlags,
+	Action: func(context *cli.Context) error {
+		if len(context.Args()) < 1 {
+			return fmt.Errorf("Missing container name")
+		}
+		resConf, err := parseResourceConfig(context)
+		if err != nil {
+			return err
+		}
+		mycli.UpdateContainer(context.Args().Get(0), resConf)
+		return nil
+	},
+}
+
+var statsCommand = cli.Command{
+	Name:  "stats",
+	Usage: "display a live stream of container(s) resource usage statistics",
+	Flags: []cli.Flag{
+		// 只输出一次，不刷新
+		cli.BoolFlag{
+			Name:  "no-stream",
+			Usage: "disable streaming stats and only pull the first result",
+		},
+		// 输出格式: table/json
+		cli.StringFlag{
+			Name:  "format",
+			Value: "table",
+			Usage: "output format, table or json",
+		},
+	},
+	Action: func(context *cli.Context) error {
+		var containerNames []string
+		for _, arg := range context.Args() {
+			containerNames = append(containerNames, arg)
+		}
+		mycli.Stats(containerNames, context.Bool("no-stream"), context.String("format"))
+		return nil
+	},
+}
+
 var networkCommand = cli.Command{
 	Name:  "network",
 	Usage: "container network commands",
@@ -262,3 +662,120 @@ var networkCommand = cli.Command{
 		},
 	},
 }
+
+var bundleFlag = cli.StringFlag{
+	Name:  "bundle, b",
+	Value: ".",
+	Usage: "path to the oci bundle directory",
+}
+
+// 获取容器id和bundle目录。oci run <bundle目录> 时以目录名作为容器id
+func ociIDAndBundle(context *cli.Context) (string, string, error) {
+	if len(context.Args()) < 1 {
+		return "", "", fmt.Errorf("Missing container id")
+	}
+	arg := context.Args().Get(0)
+	if !context.IsSet("bundle") {
+		if exist, _ := container.PathExists(filepath.Join(arg, "config.json")); exist {
+			abs, err := filepath.Abs(arg)
+			if err != nil {
+				return "", "", err
+			}
+			return filepath.Base(abs), abs, nil
+		}
+	}
+	return arg, context.String("bundle"), nil
+}
+
+var ociCommand = cli.Command{
+	Name:  "oci",
+	Usage: "manage containers from oci runtime bundles, like runc",
+	Subcommands: []cli.Command{
+		{
+			Name:      "create",
+			Usage:     "create a container from an oci bundle",
+			ArgsUsage: "<container-id>",
+			Flags:     []cli.Flag{bundleFlag},
+			Action: func(context *cli.Context) error {
+				id, bundle, err := ociIDAndBundle(context)
+				if err != nil {
+					return err
+				}
+				return mycli.OCICreate(id, bundle)
+			},
+		},
+		{
+			Name:      "start",
+			Usage:     "run the user process of a created container",
+			ArgsUsage: "<container-id>",
+			Action: func(context *cli.Context) error {
+				if len(context.Args()) < 1 {
+					return fmt.Errorf("Missing container id")
+				}
+				return mycli.OCIStart(context.Args().Get(0))
+			},
+		},
+		{
+			Name:      "state",
+			Usage:     "output the state of a container",
+			ArgsUsage: "<container-id>",
+			Action: func(context *cli.Context) error {
+				if len(context.Args()) < 1 {
+					return fmt.Errorf("Missing container id")
+				}
+				return mycli.OCIState(context.Args().Get(0))
+			},
+		},
+		{
+			Name:      "kill",
+			Usage:     "send a signal to the container init process",
+			ArgsUsage: "<container-id> [signal]",
+			Action: func(context *cli.Context) error {
+				if len(context.Args()) < 1 {
+					return fmt.Errorf("Missing container id")
+				}
+				signal := "SIGTERM"
+				if len(context.Args()) > 1 {
+					signal = context.Args().Get(1)
+				}
+				return mycli.OCIKill(context.Args().Get(0), signal)
+			},
+		},
+		{
+			Name:      "delete",
+			Usage:     "delete a stopped or created container",
+			ArgsUsage: "<container-id>",
+			Flags: []cli.Flag{
+				cli.BoolFlag{
+					Name:  "force, f",
+					Usage: "kill the container if it is still running",
+				},
+			},
+			Action: func(context *cli.Context) error {
+				if len(context.Args()) < 1 {
+					return fmt.Errorf("Missing container id")
+				}
+				return mycli.OCIDelete(context.Args().Get(0), context.Bool("force"))
+			},
+		},
+		{
+			Name:      "run",
+			Usage:     "create and start a container from an oci bundle",
+			ArgsUsage: "<container-id> | <bundle-dir>",
+			Flags: []cli.Flag{
+				bundleFlag,
+				cli.BoolFlag{
+					Name:  "detach, d",
+					Usage: "run the container in background",
+				},
+			},
+			Action: func(context *cli.Context) error {
+				id, bundle, err := ociIDAndBundle(context)
+				if err != nil {
+					return err
+				}
+				return mycli.OCIRun(id, bundle, context.Bool("detach"))
+			},
+		},
+	},
+}
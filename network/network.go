@@ -164,7 +164,7 @@ func ListNetwork() {
 		)
 	}
 	if err := w.Flush(); err != nil {
-		fmt.Errorf("Flush error %v", err)
+		log.Errorf("Flush error %v", err)
 		return
 	}
 }
@@ -257,14 +257,19 @@ func configEndpointIpAddressAndRoute(ep *Endpoint, cinfo *container.ContainerInf
 }
 
 func configPortMapping(ep *Endpoint, cinfo *container.ContainerInfo) error {
+	return iptablesPortMapping("-A", ep)
+}
+
+// 添加(-A)或删除(-D)端口映射的DNAT规则
+func iptablesPortMapping(action string, ep *Endpoint) error {
 	for _, pm := range ep.PortMapping {
 		portMapping := strings.Split(pm, ":")
 		if len(portMapping) != 2 {
 			log.Errorf("port mapping format error, %v", pm)
 			continue
 		}
-		iptablesCmd := fmt.Sprintf("-t nat -A PREROUTING -p tcp -m tcp --dport %s -j DNAT --to-destination %s:%s",
-			portMapping[0], ep.IPAddress.String(), portMapping[1])
+		iptablesCmd := fmt.Sprintf("-t nat %s PREROUTING -p tcp -m tcp --dport %s -j DNAT --to-destination %s:%s",
+			action, portMapping[0], ep.IPAddress.String(), portMapping[1])
 		cmd := exec.Command("iptables", strings.Split(iptablesCmd, " ")...)
 		//err := cmd.Run()
 		output, err := cmd.Output()
@@ -295,6 +300,7 @@ func Connect(networkName string, cinfo *container.ContainerInfo) error {
 		Network:     nw,
 		PortMapping: cinfo.PortMapping,
 	}
+	cinfo.IPAddress = ip.String()
 	// 调用网络驱动挂载和配置网络端点
 	if err = drivers[nw.Driver].Connect(nw, ep); err != nil {
 		return err
@@ -307,6 +313,54 @@ func Connect(networkName string, cinfo *container.ContainerInfo) error {
 	return configPortMapping(ep, cinfo)
 }
 
+// GetEndpointStats 读取容器网络端点的流量统计。
+// 通过主机一端的veth设备读取，主机端接收的即是容器发送的，因此rx/tx需要对调
+func GetEndpointStats(networkName string, cinfo *container.ContainerInfo) (rxBytes, txBytes uint64, err error) {
+	ep := &Endpoint{
+		ID: fmt.Sprintf("%s-%s", cinfo.Id, networkName),
+	}
+	link, err := netlink.LinkByName(ep.ID[:5])
+	if err != nil {
+		return 0, 0, fmt.Errorf("get endpoint %s link error: %v", ep.ID, err)
+	}
+	stats := link.Attrs().Statistics
+	if stats == nil {
+		return 0, 0, nil
+	}
+	return stats.TxBytes, stats.RxBytes, nil
+}
+
+// Disconnect 断开容器与网络的连接：删除端口映射规则，释放容器的ip
 func Disconnect(networkName string, cinfo *container.ContainerInfo) error {
+	nw, ok := networks[networkName]
+	if !ok {
+		return fmt.Errorf("No Such Network: %s", networkName)
+	}
+	if cinfo.IPAddress == "" {
+		return nil
+	}
+
+	ip := net.ParseIP(cinfo.IPAddress)
+	ep := &Endpoint{
+		ID:          fmt.Sprintf("%s-%s", cinfo.Id, networkName),
+		IPAddress:   ip,
+		Network:     nw,
+		PortMapping: cinfo.PortMapping,
+	}
+	if err := iptablesPortMapping("-D", ep); err != nil {
+		log.Errorf("delete port mapping error: %v", err)
+	}
+
+	// veth会随容器的network namespace一起销毁，容器进程仍在时主动删除主机一端
+	if link, err := netlink.LinkByName(ep.ID[:5]); err == nil {
+		if err := netlink.LinkDel(link); err != nil {
+			log.Errorf("delete endpoint device %s error: %v", ep.ID[:5], err)
+		}
+	}
+
+	if err := ipAllocator.Release(nw.IpNet, &ip); err != nil {
+		return fmt.Errorf("release ip %s error: %v", cinfo.IPAddress, err)
+	}
+	cinfo.IPAddress = ""
 	return nil
 }
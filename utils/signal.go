@@ -0,0 +1,70 @@
+package utils
+
+import (
+	"fmt"
+	"golang.org/x/sys/unix"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+const (
+	// glibc保留了内核的32、33两个实时信号，用户可用的SIGRTMIN从34开始
+	sigRtMin = 34
+	sigRtMax = 64
+)
+
+// ParseSignal 解析信号，支持"HUP"、"SIGHUP"这样的名称(不区分大小写)、
+// "RTMIN+1"、"SIGRTMAX-2"这样的实时信号以及1-64的数字
+func ParseSignal(rawSignal string) (syscall.Signal, error) {
+	s := strings.TrimSpace(rawSignal)
+	if s == "" {
+		return 0, fmt.Errorf("empty signal")
+	}
+	if n, err := strconv.Atoi(s); err == nil {
+		if n <= 0 || n > sigRtMax {
+			return 0, fmt.Errorf("invalid signal %s", rawSignal)
+		}
+		return syscall.Signal(n), nil
+	}
+
+	name := strings.ToUpper(s)
+	if !strings.HasPrefix(name, "SIG") {
+		name = "SIG" + name
+	}
+	if strings.HasPrefix(name, "SIGRTMIN") || strings.HasPrefix(name, "SIGRTMAX") {
+		return parseRtSignal(name, rawSignal)
+	}
+	sig := unix.SignalNum(name)
+	if sig == 0 {
+		return 0, fmt.Errorf("invalid signal %s", rawSignal)
+	}
+	return sig, nil
+}
+
+// 解析SIGRTMIN、SIGRTMIN+n、SIGRTMAX、SIGRTMAX-n格式的实时信号
+func parseRtSignal(name, rawSignal string) (syscall.Signal, error) {
+	base, offset := sigRtMin, name[len("SIGRTMIN"):]
+	if strings.HasPrefix(name, "SIGRTMAX") {
+		base = sigRtMax
+	}
+	if offset == "" {
+		return syscall.Signal(base), nil
+	}
+
+	sign := "+"
+	if base == sigRtMax {
+		sign = "-"
+	}
+	if !strings.HasPrefix(offset, sign) {
+		return 0, fmt.Errorf("invalid signal %s", rawSignal)
+	}
+	n, err := strconv.Atoi(offset[1:])
+	if err != nil || n < 0 || n > sigRtMax-sigRtMin || strings.HasPrefix(offset[1:], "+") {
+		return 0, fmt.Errorf("invalid signal %s", rawSignal)
+	}
+	if base == sigRtMax {
+		n = -n
+	}
+	return syscall.Signal(base + n), nil
+}
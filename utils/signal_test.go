@@ -0,0 +1,35 @@
+package utils
+
+import (
+	"syscall"
+	"testing"
+)
+
+func TestParseSignal(t *testing.T) {
+	cases := map[string]syscall.Signal{
+		"HUP":        syscall.SIGHUP,
+		"sighup":     syscall.SIGHUP,
+		"SIGTERM":    syscall.SIGTERM,
+		"usr1":       syscall.SIGUSR1,
+		"9":          syscall.SIGKILL,
+		"40":         syscall.Signal(40),
+		"64":         syscall.Signal(64),
+		"RTMIN":      syscall.Signal(34),
+		"SIGRTMIN+3": syscall.Signal(37),
+		"rtmin+30":   syscall.Signal(64),
+		"SIGRTMAX":   syscall.Signal(64),
+		"RTMAX-2":    syscall.Signal(62),
+	}
+	for s, want := range cases {
+		got, err := ParseSignal(s)
+		if err != nil || got != want {
+			t.Errorf("ParseSignal(%q) = %v, %v; want %v", s, got, err, want)
+		}
+	}
+
+	for _, s := range []string{"", "0", "-1", "65", "999", "NOPE", "SIG", "RTMIN-1", "RTMAX+1", "RTMIN+31", "RTMIN+", "RTMIN+x", "RTMAX-+1"} {
+		if _, err := ParseSignal(s); err == nil {
+			t.Errorf("ParseSignal(%q) should fail", s)
+		}
+	}
+}
@@ -0,0 +1,49 @@
+package utils
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+var binaryAbbrs = []string{"B", "KiB", "MiB", "GiB", "TiB", "PiB"}
+
+// 匹配 "512m"、"1.5g"、"2GiB"、"100kb"、"1024" 等格式
+var sizeRegex = regexp.MustCompile(`^(\d+(?:\.\d+)?) ?([kKmMgGtTpP])?[iI]?[bB]?$`)
+
+var binaryMap = map[string]int64{
+	"k": 1 << 10,
+	"m": 1 << 20,
+	"g": 1 << 30,
+	"t": 1 << 40,
+	"p": 1 << 50,
+}
+
+// BytesSize 将字节数转换为便于阅读的二进制单位字符串，如 "1.5MiB"
+func BytesSize(size float64) string {
+	i := 0
+	for size >= 1024 && i < len(binaryAbbrs)-1 {
+		size /= 1024
+		i++
+	}
+	return fmt.Sprintf("%.4g%s", size, binaryAbbrs[i])
+}
+
+// RAMInBytes 将 "512m"、"2g" 这类便于阅读的大小转换为字节数，单位按1024进制计算
+func RAMInBytes(size string) (int64, error) {
+	matches := sizeRegex.FindStringSubmatch(strings.TrimSpace(size))
+	if len(matches) != 3 {
+		return -1, fmt.Errorf("invalid size: '%s'", size)
+	}
+
+	n, err := strconv.ParseFloat(matches[1], 64)
+	if err != nil {
+		return -1, fmt.Errorf("invalid size: '%s'", size)
+	}
+
+	if mul, ok := binaryMap[strings.ToLower(matches[2])]; ok {
+		n *= float64(mul)
+	}
+	return int64(n), nil
+}
@@ -0,0 +1,30 @@
+package utils
+
+import "testing"
+
+func TestRAMInBytes(t *testing.T) {
+	cases := map[string]int64{
+		"1024":   1024,
+		"32kb":   32 * 1024,
+		"512m":   512 * 1024 * 1024,
+		"2g":     2 * 1024 * 1024 * 1024,
+		"1.5GiB": 3 * 512 * 1024 * 1024,
+		"10 MB":  10 * 1024 * 1024,
+	}
+	for size, want := range cases {
+		got, err := RAMInBytes(size)
+		if err != nil || got != want {
+			t.Errorf("RAMInBytes(%q) = %d, %v; want %d", size, got, err, want)
+		}
+	}
+
+	for _, size := range []string{"", "m", "-1", "12x", "1.2.3g"} {
+		if _, err := RAMInBytes(size); err == nil {
+			t.Errorf("RAMInBytes(%q) should fail", size)
+		}
+	}
+}
+
+func TestBytesSize(t *testing.T) {
+	t.Logf("%s %s %s", BytesSize(100), BytesSize(1536), BytesSize(3*1024*1024*1024))
+}
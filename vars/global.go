@@ -3,19 +3,33 @@ package vars
 import "path"
 
 var (
-	RUNNING             string = "running"
-	STOP                string = "stopped"
+	CREATED             string = "created"    // 已创建，容器进程尚未启动
+	RUNNING             string = "running"    // 容器进程运行中
+	PAUSED              string = "paused"     // 容器进程被冻结
+	EXIT                string = "exited"     // 容器进程已退出，并记录了退出码
+	RESTARTING          string = "restarting" // 容器进程已退出，shim正在等待按重启策略重新启动
+	DEAD                string = "dead"       // 容器进程已不存在，但没能记录退出码，或者清理失败
+	REMOVING            string = "removing"   // 正在删除
+	TimeFormat          string = "2006-01-02 15:04:05"
 	RootPath            string = "/tmp/docker" // docker根目录
 	ConfigName          string = "config.json"
+	SpecName            string = "spec.json" // 创建容器时使用的完整配置
 	ContainerLogFile    string = "container.log"
-	ContainersRootPath  string = path.Join(RootPath, "containers")     // 容器根目录
-	NetworkRootPath     string = path.Join(RootPath, "network/")       // 网络根目录
-	NetworkDir          string = path.Join(NetworkRootPath, "network") // 网络配置
-	IPAMDir             string = path.Join(NetworkRootPath, "ipam")    // ipam配置
-	ImagesDir           string = path.Join(RootPath, "images")
+	ShimLogFile         string = "shim.log"
+	ContainersRootPath  string = path.Join(RootPath, "containers")             // 容器根目录
+	NetworkRootPath     string = path.Join(RootPath, "network/")               // 网络根目录
+	NetworkDir          string = path.Join(NetworkRootPath, "network")         // 网络配置
+	IPAMDir             string = path.Join(NetworkRootPath, "ipam")            // ipam配置
+	ImagesDir           string = path.Join(RootPath, "images")                 // 旧版本的镜像tar包，使用时自动导入镜像存储
+	ImageRootPath       string = path.Join(RootPath, "image")                  // 镜像存储根目录
+	LayersDir           string = path.Join(ImageRootPath, "layers/sha256")     // 镜像层，每层按内容的sha256存放，解压后在diff目录下
+	LayerLinkDir        string = path.Join(ImageRootPath, "l")                 // 镜像层diff目录的短链接，缩短overlay的挂载参数
+	ImageDBDir          string = path.Join(ImageRootPath, "imagedb/sha256")    // 镜像的config和manifest，按config的sha256存放
+	RepositoriesFile    string = path.Join(ImageRootPath, "repositories.json") // 镜像名name:tag到镜像ID的索引
 	DefaultInfoLocation string = path.Join(ContainersRootPath, "%s")
 	LowerDir            string = path.Join(ContainersRootPath, "%s/lowerLayer") // overlay文件系统层
 	UpperDir            string = path.Join(ContainersRootPath, "%s/upperLayer") // overlay文件系统层
 	WorkDir             string = path.Join(ContainersRootPath, "%s/workLayer")  // overlay文件系统层
 	MntDir              string = path.Join(ContainersRootPath, "%s/mnt")        // overlay文件系统层
+	CgroupParent        string = "mydocker"                                     // 容器cgroup的父目录，每个容器为mydocker/<容器ID>
 )